@@ -1,12 +1,16 @@
 package conf
 
 import (
+	"binlog2sql_go/utils"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
 )
 
 var (
@@ -48,6 +52,31 @@ func (ssf *stringSliceFlag) ToUpper() stringSliceFlag {
 	return result
 }
 
+// sizeFlag 为字节数，支持 K、M、G 后缀（1024进制），如 10M
+type sizeFlag uint64
+
+func (sf *sizeFlag) String() string {
+	return strconv.FormatUint(uint64(*sf), 10)
+}
+
+func (sf *sizeFlag) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	unit := uint64(1)
+	for i, suffix := range []string{"K", "M", "G"} {
+		if strings.HasSuffix(value, suffix) || strings.HasSuffix(value, suffix+"B") {
+			unit = 1 << (10 * uint(i+1))
+			value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*sf = sizeFlag(n * unit)
+	return nil
+}
+
 type Config struct {
 	version          bool
 	Host             string
@@ -64,15 +93,89 @@ type Config struct {
 	StartDatetime    time.Time
 	stopDatetimeStr  string
 	StopDatetime     time.Time
-	Databases        stringSliceFlag
-	Tables           stringSliceFlag
-	Local            bool
-	LocalFile        string
-	Simple           bool
-	StopNever        bool
-	OnlyDML          bool
-	SqlType          stringSliceFlag
-	// Threads          uint
+	startGtidStr     string
+	stopGtidStr      string
+	includeGtidsStr  string
+	excludeGtidsStr  string
+	// StartGtid 为已执行的GTID集合，从其后的事务开始解析
+	StartGtid mysql.GTIDSet
+	// StopGtid 中的事务解析完后停止
+	StopGtid     mysql.GTIDSet
+	IncludeGtids mysql.GTIDSet
+	ExcludeGtids mysql.GTIDSet
+	Databases    stringSliceFlag
+	Tables       stringSliceFlag
+	Local        bool
+	LocalFiles   stringSliceFlag
+	// SchemaFile 为离线解析使用的表结构文件(mysqldump --no-data 的SQL或JSON)
+	SchemaFile string
+	// ConnectDb 是否需要连接数据库：在线解析必须连接，离线解析只有指定了连接参数时才连接
+	ConnectDb bool
+	Simple    bool
+	StopNever bool
+	OnlyDML   bool
+	// AllDDL 为true时输出所有DDL，不按库表过滤条件过滤
+	AllDDL bool
+	// NoBackslashEscapes 生成的SQL将在 sql_mode 含 NO_BACKSLASH_ESCAPES 的实例上执行
+	NoBackslashEscapes bool
+	// WhereMode 为UPDATE/DELETE的WHERE条件生成方式：auto、key、full
+	WhereMode string
+	SqlType   stringSliceFlag
+	// OutputFormat 为输出格式：sql、json（每行数据变更输出一个JSON对象）、debezium（Debezium变更事件格式）、
+	// csv/tsv（每个表导出到 OutputDir 下单独的文件）
+	OutputFormat string
+	OutputDir    string
+	// Squash 为true时按 表+主键 合并解析范围内的修改，只输出每行的净变更
+	Squash bool
+	// DetectConflicts 为true时回滚后继续扫描停止位置之后的binlog，报告回滚范围内被再次修改的行
+	DetectConflicts bool
+	// Verify 为true时不输出回滚SQL，只在当前库中检查回滚的 UPDATE/DELETE 要修改的行是否与binlog一致
+	Verify bool
+	// ApplyTo 为目标MySQL的DSN，不为空时直接在目标库执行生成的SQL，进度保存在 CheckpointTable 或 CheckpointFile 中
+	ApplyTo         string
+	CheckpointFile  string
+	CheckpointTable string
+	// OutputFile 为输出文件，为空时输出到标准输出；RotateSize 不为0时按大小滚动输出文件；Gzip 压缩输出
+	OutputFile string
+	RotateSize sizeFlag
+	Gzip       bool
+	// Transaction 为true时按事务输出，每个事务用 BEGIN;/COMMIT; 包裹
+	Transaction bool
+	// MinRows、MinBytes、MinDuration 为事务级过滤条件，只输出影响行数、binlog大小、持续时间达到阈值的事务
+	MinRows     uint
+	MinBytes    sizeFlag
+	MinDuration time.Duration
+	// Threads 为并发解码行事件及生成SQL的goroutine数，为1时在读取binlog的goroutine中顺序处理
+	Threads uint
+	// TimeZone 为 -time-zone，用于解析不带时区的 -start-datetime/-stop-datetime、输出事件时间及TIMESTAMP列的值
+	timeZoneStr string
+	TimeZone    *time.Location
+	// IgnoreDatabases、IgnoreTables 为排除的库表，格式与 Databases、Tables 相同
+	IgnoreDatabases stringSliceFlag
+	IgnoreTables    stringSliceFlag
+}
+
+// Location 返回 -time-zone 指定的时区，未设置时为本地时区
+func (c *Config) Location() *time.Location {
+	if c.TimeZone == nil {
+		return time.Local
+	}
+	return c.TimeZone
+}
+
+// EventTime 返回binlog事件时间戳在 -time-zone 中的时间
+func (c *Config) EventTime(timestamp uint32) time.Time {
+	return time.Unix(int64(timestamp), 0).In(c.Location())
+}
+
+// FilterTransaction 是否设置了事务级的过滤条件
+func (c *Config) FilterTransaction() bool {
+	return c.MinRows != 0 || c.MinBytes != 0 || c.MinDuration != 0
+}
+
+// WholeTransaction 是否以事务为单位处理：-transaction、事务级过滤条件、结构化输出(需要XID)或 -apply-to
+func (c *Config) WholeTransaction() bool {
+	return c.Transaction || c.FilterTransaction() || c.OutputFormat != "sql" || c.ApplyTo != ""
 }
 
 func NewConfig() *Config {
@@ -82,7 +185,7 @@ func NewConfig() *Config {
 func usage() {
 	fmt.Fprintf(os.Stderr, `
 Usage: binlog2sql_go [[-h] | [-host] HOST] [[-u] | [-user] USER] [[-P] | [-port] PORT] [[-p] | [-password] PASSWORD]
-                  [-local -local-files] | [-start-file STARTFILE [-stop-file ENDFILE]]
+                  [-local -local-file FILES] | [-start-file STARTFILE [-stop-file ENDFILE]]
                   [-start-position STARTPOS] [-stop-position ENDPOS]
                   [-start-datetime STARTTIME] [-stop-datetime STOPTIME]
                   [-stop-never] [-help] [[-d] | [-databases] [DATABASES,[DATABASES ...]]]
@@ -102,30 +205,63 @@ func ParseConfig(conf *Config) {
 	flag.StringVar(&conf.User, "u", "root", "MySQL Username to log in as (short option)")
 	flag.StringVar(&conf.Password, "password", "", "MySQL Password to use")
 	flag.StringVar(&conf.Password, "p", "", "MySQL Password to use (short option)")
-	// flag.UintVar(&conf.Threads, "threads", 8, "The number of concurrent threads than handle the binlog event.")
+	flag.UintVar(&conf.Threads, "threads", 1, "The number of concurrent threads that decode rows events and generate SQL. Output keeps the binlog order. 1 parses in the reading goroutine without the pipeline. Whether more threads are faster has not been measured yet, see README")
 	flag.UintVar(&conf.Port, "port", 3306, "MySQL Port to use")
 	flag.UintVar(&conf.Port, "P", 3306, "MySQL Port to use (short option)")
-	flag.StringVar(&conf.StartFile, "start-file", "", "Start core file to be parsed")
+	flag.StringVar(&conf.StartFile, "start-file", "", "Start core file to be parsed. Not needed with -start-gtid, or with -start-datetime which locates it by binary search over the first event time of each file")
 	flag.StringVar(&conf.StopFile, "stop-file", "", "Stop core file to be parsed. default: '-start-file'")
 	flag.UintVar(&conf.StartPosition, "start-position", 4, "Start position of the -start-file")
 	flag.UintVar(&conf.StopPosition, "stop-position", 0, "Stop position of -stop-file. default: latest position of '-stop-file'")
-	flag.StringVar(&conf.startDatetimeStr, "start-datetime", "", "Start reading the core at first event having a datetime equal or posterior to the argument; the argument must be a date and time in the Local time zone, in any format accepted by the MySQL server for DATETIME and TIMESTAMP types, for example: 2004-12-25 11:25:56 (you should probably use quotes for your shell to set it properly).")
-	flag.StringVar(&conf.stopDatetimeStr, "stop-datetime", "", "  Stop reading the core at first event having a datetime equal or posterior to the argument; the argument must be a date and time in the Local time zone, in any format accepted by the MySQL server for DATETIME and TIMESTAMP types, for example: 2004-12-25 11:25:56 (you should probably use quotes for your shell to set it properly).")
+	flag.StringVar(&conf.startDatetimeStr, "start-datetime", "", "Start reading the core at first event having a datetime equal or posterior to the argument; the argument is a date and time in the -time-zone, for example: 2004-12-25 11:25:56 (you should probably use quotes for your shell to set it properly), an RFC3339 time with offset such as 2004-12-25T11:25:56+08:00, or a Unix timestamp in seconds.")
+	flag.StringVar(&conf.stopDatetimeStr, "stop-datetime", "", "Stop reading the core at first event having a datetime posterior to the argument; accepts the same formats as -start-datetime.")
+	flag.StringVar(&conf.timeZoneStr, "time-zone", "Local", "Time zone for -start-datetime/-stop-datetime without offset, event times in output and TIMESTAMP column values: an IANA name such as Asia/Shanghai, UTC, Local or an offset such as +08:00")
+	flag.StringVar(&conf.startGtidStr, "start-gtid", "", "Start after the given executed GTID set, e.g. 3E11FA47-71CA-11E1-9E33-C80AA9429562:1-23. Online parsing syncs by GTID and -start-file is not needed")
+	flag.StringVar(&conf.stopGtidStr, "stop-gtid", "", "Stop after the transaction with the given GTID, e.g. 3E11FA47-71CA-11E1-9E33-C80AA9429562:57")
+	flag.StringVar(&conf.includeGtidsStr, "include-gtids", "", "Only process transactions in the given GTID set")
+	flag.StringVar(&conf.excludeGtidsStr, "exclude-gtids", "", "Skip transactions in the given GTID set")
 	flag.BoolVar(&conf.Flashback, "flashback", false, "Is Flashback data to start_position of start-file (default false)")
 	flag.BoolVar(&conf.Flashback, "B", false, "Is Flashback data to start_position of start-file (default false) (short option)")
-	flag.BoolVar(&conf.NoPk, "noPK", false, "Generate insert sql without primary key if exists (default false)")
+	flag.BoolVar(&conf.NoPk, "noPK", false, "Generate insert sql without AUTO_INCREMENT columns so the target assigns new ids; other primary key columns are kept. With only binlog metadata a primary key of a single integer column is omitted (default false)")
 	flag.Var(&conf.SqlType, "sql-type", "Original sql type you want to process, support INSERT, UPDATE, DELETE. (default INSERT,UPDATE,DELETE)")
-	flag.Var(&conf.Databases, "databases", "Comma-separated list of dbs you want to process")
+	flag.Var(&conf.Databases, "databases", "Comma-separated list of dbs you want to process. Supports wildcards (* ? [...]) and regular expressions prefixed with ~, e.g. 'shop_*' or '~shop_\\d+'")
 	flag.Var(&conf.Databases, "d", "Comma-separated list of dbs you want to process (short option)")
-	flag.Var(&conf.Tables, "tables", "Comma-separated list of Tables you want to process")
+	flag.Var(&conf.Tables, "tables", "Comma-separated list of Tables you want to process, as table or db.table (escape dots in names as \\.). Supports the same wildcards and ~ regular expressions as -databases; an unqualified table matches in every database")
 	flag.Var(&conf.Tables, "t", "Comma-separated list of Tables you want to process (short option)")
-	flag.StringVar(&conf.LocalFile, "local-file", "", "The binary logs in Local")
+	flag.Var(&conf.IgnoreDatabases, "ignore-databases", "Comma-separated list of dbs to exclude, same format as -databases")
+	flag.Var(&conf.IgnoreTables, "ignore-tables", "Comma-separated list of Tables to exclude, same format as -tables")
+	flag.Var(&conf.LocalFiles, "local-file", "The binary logs in Local, comma-separated or repeated. Accepts files, globs like 'mysql-bin.*', directories and mysql-bin.index files")
 	flag.BoolVar(&conf.Local, "local", false, "Is the binary log exist at Local?")
+	flag.StringVar(&conf.SchemaFile, "schema-file", "", "Table schemas for offline parsing, a 'mysqldump --no-data' sql file or a json file like [{\"schema\":\"db\",\"table\":\"t\",\"columns\":[\"id\",\"a\"],\"pks\":[\"id\"]}]")
 	flag.BoolVar(&conf.Simple, "simple", false, "Generate update sql in Simple mode, the unchanged column will be excluded ")
-	flag.BoolVar(&conf.StopNever, "stop-never", false, "Continuously parse binlog. default: stop at the latest event of '-stop-file'. ")
+	flag.BoolVar(&conf.StopNever, "stop-never", false, "Continuously parse binlog until -stop-datetime if given. default: stop at the latest event of '-stop-file'. ")
 	flag.BoolVar(&conf.OnlyDML, "only-dml", false, "only print dml, ignore ddl. (default false) ")
+	flag.BoolVar(&conf.AllDDL, "all-ddl", false, "Print DDL and other statements of all databases and tables. By default they are filtered by the tables they modify with -databases/-tables/-ignore-databases/-ignore-tables (default false)")
+	flag.StringVar(&conf.WhereMode, "where-mode", "auto", "How to build WHERE of UPDATE/DELETE: auto: primary key or not null unique key if exists, else all columns; key: key columns only, error on keyless tables; full: all columns")
+	flag.BoolVar(&conf.NoBackslashEscapes, "no-backslash-escapes", false, "Escape string literals for a server running with sql_mode NO_BACKSLASH_ESCAPES, only quotes are doubled. (default false)")
+	flag.StringVar(&conf.OutputFormat, "output-format", "sql", "Output format: sql; json: one JSON object per row change (JSON Lines) with before/after values, primary key, binlog position, server id, gtid and xid; debezium: one Debezium change event envelope per row change; csv/tsv: export each table's row changes to its own file in -output-dir")
+	flag.BoolVar(&conf.Squash, "squash", false, "Collapse all changes of the same row (table + primary key) in the range into one net INSERT/UPDATE/DELETE, or its inverse with -flashback. Output is ordered by table name and primary key value (numbers by value, other types by their SQL literal). Tables without primary key are not squashed: their rows are output as they are read, in binlog order (reversed with -flashback), before all squashed rows. DDL is not output (default false)")
+	flag.BoolVar(&conf.DetectConflicts, "detect-conflicts", false, "With -flashback, keep scanning the binlogs after the stop point to their end and report to stderr, by primary key, the rows of the rollback set that were modified again later (default false)")
+	flag.BoolVar(&conf.Verify, "verify", false, "Dry run of -flashback: instead of printing the rollback sql, check with read-only SELECTs on the server whether the rows the rollback UPDATE/DELETE expect still exist, report conflicts and a summary (default false)")
+	flag.StringVar(&conf.ApplyTo, "apply-to", "", "Execute the generated sql on the target MySQL instead of printing it, one transaction at a time, e.g. 'user:password@tcp(127.0.0.1:3306)/'. Progress is checkpointed and an interrupted run resumes from the checkpoint")
+	flag.StringVar(&conf.CheckpointFile, "checkpoint-file", "", "Checkpoint file of -apply-to (default binlog2sql_go.checkpoint). It is saved after each transaction commits; a run killed in between leaves the transaction marked as pending and the next run stops until it is checked, use -checkpoint-table to avoid this")
+	flag.StringVar(&conf.CheckpointTable, "checkpoint-table", "", "Save the checkpoint of -apply-to in this table (db.table) of the target in the same transaction as the applied sql, instead of -checkpoint-file")
+	flag.StringVar(&conf.OutputFile, "output-file", "", "Write output to this file instead of stdout")
+	flag.Var(&conf.RotateSize, "rotate-size", "Rotate -output-file when it reaches this size, accepts K/M/G suffix, e.g. 100M. Files are named <output-file>.000001, <output-file>.000002 ...")
+	flag.BoolVar(&conf.Gzip, "gzip", false, "Compress the output with gzip (default false)")
+	flag.StringVar(&conf.OutputDir, "output-dir", ".", "Directory of the per-table files of -output-format csv/tsv")
+	flag.BoolVar(&conf.Transaction, "transaction", false, "Group sql by transaction, wrapped in BEGIN;/COMMIT; with xid and time comments. With -flashback transactions are rolled back in reverse order (default false)")
+	flag.UintVar(&conf.MinRows, "min-rows", 0, "Only output transactions that changed at least this many rows")
+	flag.Var(&conf.MinBytes, "min-bytes", "Only output transactions whose binlog events take at least this size, accepts K/M/G suffix, e.g. 10M")
+	flag.DurationVar(&conf.MinDuration, "min-duration", 0, "Only output transactions that lasted at least this long from BEGIN to COMMIT, e.g. 30s")
 	flag.Parse()
 	flag.Usage = usage
+	conf.ConnectDb = !conf.Local
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "host", "h", "user", "u", "password", "p", "port", "P":
+			conf.ConnectDb = true
+		}
+	})
 	if help {
 		flag.Usage()
 	}
@@ -135,12 +271,13 @@ func ParseConfig(conf *Config) {
 		os.Exit(0)
 	}
 	flag.VisitAll(func(f *flag.Flag) {
-		if conf.Local && conf.LocalFile == "" || (conf.LocalFile != "" && !conf.Local) {
+		if conf.Local && conf.LocalFiles.Len() == 0 || (conf.LocalFiles.Len() != 0 && !conf.Local) {
 			fmt.Println("Error: -Local & -Local-files must be used together.")
 			flag.Usage()
 			os.Exit(1)
 		}
-		if !conf.Local && conf.StartFile == "" {
+		// 指定 -start-datetime 时按时间查找起始文件
+		if !conf.Local && conf.StartFile == "" && conf.startGtidStr == "" && conf.startDatetimeStr == "" {
 			fmt.Println("Error: lack of parameter: -start-file ")
 			flag.Usage()
 			os.Exit(1)
@@ -148,17 +285,21 @@ func ParseConfig(conf *Config) {
 		if conf.StopFile == "" {
 			conf.StopFile = conf.StartFile
 		}
+		var err error
+		if conf.TimeZone, err = parseTimeZone(conf.timeZoneStr); err != nil {
+			fmt.Printf("Error: -time-zone format error: %v\n", err)
+			flag.Usage()
+			os.Exit(1)
+		}
 		if conf.startDatetimeStr != "" {
-			var err error
-			if conf.StartDatetime, err = time.Parse("2006-01-02 15:04:05", conf.startDatetimeStr); err != nil {
+			if conf.StartDatetime, err = parseDatetime(conf.startDatetimeStr, conf.TimeZone); err != nil {
 				fmt.Println("Error: -start-datetime format error")
 				flag.Usage()
 				os.Exit(1)
 			}
 		}
 		if conf.stopDatetimeStr != "" {
-			var err error
-			if conf.StopDatetime, err = time.Parse("2006-01-02 15:04:05", conf.stopDatetimeStr); err != nil {
+			if conf.StopDatetime, err = parseDatetime(conf.stopDatetimeStr, conf.TimeZone); err != nil {
 				fmt.Println("Error: -stop-datetime format error")
 				flag.Usage()
 				os.Exit(1)
@@ -168,11 +309,87 @@ func ParseConfig(conf *Config) {
 			fmt.Println("Error: -stop-datetime Before -start-datetime")
 			os.Exit(1)
 		}
+		for _, g := range []struct {
+			name string
+			str  string
+			set  *mysql.GTIDSet
+		}{
+			{"-start-gtid", conf.startGtidStr, &conf.StartGtid},
+			{"-stop-gtid", conf.stopGtidStr, &conf.StopGtid},
+			{"-include-gtids", conf.includeGtidsStr, &conf.IncludeGtids},
+			{"-exclude-gtids", conf.excludeGtidsStr, &conf.ExcludeGtids},
+		} {
+			if g.str == "" {
+				continue
+			}
+			set, err := mysql.ParseMysqlGTIDSet(g.str)
+			if err != nil {
+				fmt.Printf("Error: %s format error, only MySQL GTID sets are supported: %v\n", g.name, err)
+				flag.Usage()
+				os.Exit(1)
+			}
+			*g.set = set
+		}
+		if conf.Flashback && conf.StopNever {
+			fmt.Println("Error: only one of Flashback or stop-never can be True")
+			flag.Usage()
+			os.Exit(1)
+		}
 		if conf.Flashback && conf.NoPk {
 			fmt.Println("Error: only one of Flashback or no_pk can be True")
 			flag.Usage()
 			os.Exit(1)
 		}
+		if conf.OutputFormat = strings.ToLower(conf.OutputFormat); !utils.Contains([]string{"sql", "json", "debezium", "csv", "tsv"}, conf.OutputFormat) {
+			fmt.Println("Error: -output-format must be one of sql, json, debezium, csv, tsv")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.Flashback && conf.OutputFormat != "sql" {
+			fmt.Println("Error: -flashback only supports -output-format sql")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.Squash && (conf.Transaction || conf.FilterTransaction() || conf.ApplyTo != "" || conf.OutputFormat != "sql") {
+			fmt.Println("Error: -squash can not be used with -transaction, -min-rows/-min-bytes/-min-duration, -apply-to or -output-format other than sql")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.AllDDL && conf.OnlyDML {
+			fmt.Println("Error: only one of -all-ddl or -only-dml can be True")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.DetectConflicts && !conf.Flashback {
+			fmt.Println("Error: -detect-conflicts must be used with -flashback")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.Verify && !conf.Flashback {
+			fmt.Println("Error: -verify must be used with -flashback")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.Verify && conf.ApplyTo != "" {
+			fmt.Println("Error: only one of -verify or -apply-to can be used")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.ApplyTo != "" && conf.OutputFormat != "sql" {
+			fmt.Println("Error: -apply-to only supports -output-format sql")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.RotateSize != 0 && conf.OutputFile == "" {
+			fmt.Println("Error: -rotate-size needs -output-file")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if conf.WhereMode = strings.ToLower(conf.WhereMode); conf.WhereMode != "auto" && conf.WhereMode != "key" && conf.WhereMode != "full" {
+			fmt.Println("Error: -where-mode must be one of auto, key, full")
+			flag.Usage()
+			os.Exit(1)
+		}
 		if conf.SqlType.Len() == 0 {
 			_ = conf.SqlType.Set("INSERT")
 			_ = conf.SqlType.Set("DELETE")
@@ -0,0 +1,60 @@
+package conf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var tzOffsetRegexp = regexp.MustCompile(`^(?:UTC|GMT)?([+-])(\d{1,2}):?(\d{2})?$`)
+
+// parseTimeZone 解析 -time-zone，支持IANA时区名(如 Asia/Shanghai)、UTC、Local 及偏移量(如 +08:00、-0530、UTC+8)
+func parseTimeZone(s string) (*time.Location, error) {
+	switch strings.ToUpper(s) {
+	case "", "LOCAL", "SYSTEM":
+		return time.Local, nil
+	case "UTC", "GMT", "Z":
+		return time.UTC, nil
+	}
+	if m := tzOffsetRegexp.FindStringSubmatch(strings.ToUpper(s)); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("invalid time zone offset %s", s)
+		}
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(s, offset), nil
+	}
+	return time.LoadLocation(s)
+}
+
+// datetimeLayouts 为不带时区的时间格式，按 -time-zone 解析
+var datetimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDatetime 解析 -start-datetime/-stop-datetime：不带时区的时间按 loc 解析，
+// 也支持带时区的RFC3339(如 2004-12-25T11:25:56+08:00)及Unix时间戳(秒)
+func parseDatetime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range datetimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized datetime %q", s)
+}
@@ -0,0 +1,57 @@
+package conf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeZone(t *testing.T) {
+	ts := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]int{
+		"UTC":           0,
+		"+08:00":        8 * 3600,
+		"-0530":         -(5*3600 + 30*60),
+		"UTC+8":         8 * 3600,
+		"Asia/Shanghai": 8 * 3600,
+	}
+	for s, want := range cases {
+		loc, err := parseTimeZone(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if _, offset := ts.In(loc).Zone(); offset != want {
+			t.Errorf("%s: got offset %d, want %d", s, offset, want)
+		}
+	}
+	for _, s := range []string{"+25:00", "Mars/Base"} {
+		if _, err := parseTimeZone(s); err == nil {
+			t.Errorf("%s: expect error", s)
+		}
+	}
+}
+
+func TestParseDatetime(t *testing.T) {
+	loc := time.FixedZone("+08:00", 8*3600)
+	want := time.Date(2004, 12, 25, 3, 25, 56, 0, time.UTC)
+	for _, s := range []string{
+		"2004-12-25 11:25:56",
+		"2004-12-25T11:25:56",
+		"2004-12-25T11:25:56+08:00",
+		"2004-12-25T03:25:56Z",
+		"1103945156",
+	} {
+		got, err := parseDatetime(s, loc)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%s: got %v, want %v", s, got, want)
+		}
+	}
+	if got, err := parseDatetime("2004-12-25", loc); err != nil || !got.Equal(time.Date(2004, 12, 25, 0, 0, 0, 0, loc)) {
+		t.Errorf("date only: got %v, %v", got, err)
+	}
+	if _, err := parseDatetime("25/12/2004", loc); err == nil {
+		t.Error("expect error")
+	}
+}
@@ -5,29 +5,35 @@ import (
 	"sync"
 )
 
+// Cache 按TableID缓存表结构定义，同一TableID读到新的TableMapEvent时重新生成，表结构发生变化(DDL)时需要调用 Reset
 type Cache struct {
-	cache map[uint64][]string
+	cache map[uint64]*Table
 	rl    sync.RWMutex
 }
 
 func NewCache() *Cache {
-	return &Cache{cache: make(map[uint64][]string)}
+	return &Cache{cache: make(map[uint64]*Table)}
 }
 
-func (c *Cache) Get(re *replication.RowsEvent, fn func(string, string) ([]string, error)) (res []string, err error) {
-	tableId, schema, table := re.TableID, string(re.Table.Schema), string(re.Table.Table)
-	if c.cache == nil {
-		c.cache = make(map[uint64][]string)
-	}
+// Get 返回RowsEvent对应的表结构定义，不存在或不是由该事件的TableMapEvent生成时调用 build 生成
+func (c *Cache) Get(re *replication.RowsEvent, build func(*replication.RowsEvent) (*Table, error)) (res *Table, err error) {
 	c.rl.Lock()
 	defer c.rl.Unlock()
+	if c.cache == nil {
+		c.cache = make(map[uint64]*Table)
+	}
 	var ok bool
-	if res, ok = c.cache[tableId]; !ok {
-		res, err = fn(schema, table)
+	if res, ok = c.cache[re.TableID]; !ok || res.Meta != re.Table {
+		res, err = build(re)
 		if err != nil {
 			return
 		}
-		c.cache[tableId] = res
+		c.cache[re.TableID] = res
 	}
 	return
 }
+func (c *Cache) Reset() {
+	c.rl.Lock()
+	defer c.rl.Unlock()
+	c.cache = make(map[uint64]*Table)
+}
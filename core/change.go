@@ -0,0 +1,106 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// RowChange 为一行数据的变更，-output-format json 时每行输出一个JSON对象
+type RowChange struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	// Type 为 INSERT、UPDATE、DELETE
+	Type   string                 `json:"type"`
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	// PrimaryKey 为主键（没有主键时为非空唯一索引）的值，无键表为空
+	PrimaryKey map[string]interface{} `json:"primary_key"`
+	File       string                 `json:"file"`
+	StartPos   uint32                 `json:"start_pos"`
+	EndPos     uint32                 `json:"end_pos"`
+	Timestamp  uint32                 `json:"timestamp"`
+	ServerId   uint32                 `json:"server_id"`
+	Gtid       string                 `json:"gtid,omitempty"`
+	Xid        uint64                 `json:"xid,omitempty"`
+	// Row 为该行在事件中的序号，从0开始
+	Row int `json:"-"`
+	// Columns 为表的列，按表定义的顺序
+	Columns []string `json:"-"`
+}
+
+// RowChangesFromRowsEvent 将RowsEvent按行转换为RowChange，库表及 -sql-type 过滤条件与生成SQL时相同。
+// File、Gtid、Xid 由调用方填写
+func RowChangesFromRowsEvent(e *replication.BinlogEvent, cfg *conf.Config) (changes []*RowChange, err error) {
+	rowsEvent, ok := e.Event.(*replication.RowsEvent)
+	if !ok {
+		return nil, fmt.Errorf("event is not a RowsEvent")
+	}
+	if !matchTable(rowsEvent) {
+		return
+	}
+	sqlType := eventTypeToString(e.Header.EventType)
+	if !cfg.SqlType.In(sqlType) {
+		return
+	}
+	t, err := eventTable(rowsEvent, cfg)
+	if err != nil {
+		return nil, err
+	}
+	newChange := func(before, after []interface{}) *RowChange {
+		c := &RowChange{
+			Schema:    t.Schema,
+			Table:     t.Table,
+			Type:      sqlType,
+			Before:    t.rowMap(before),
+			After:     t.rowMap(after),
+			StartPos:  e.Header.LogPos - e.Header.EventSize,
+			EndPos:    e.Header.LogPos,
+			Timestamp: e.Header.Timestamp,
+			ServerId:  e.Header.ServerID,
+			Row:       len(changes),
+			Columns:   t.Columns,
+		}
+		row := after
+		if row == nil {
+			row = before
+		}
+		if keys := t.keyColumns(); keys != nil {
+			c.PrimaryKey = make(map[string]interface{}, len(keys))
+			for _, i := range t.columnIndexes(keys) {
+				c.PrimaryKey[t.Columns[i]] = t.plainValue(i, row[i])
+			}
+		}
+		return c
+	}
+	switch sqlType {
+	case "INSERT":
+		for _, row := range rowsEvent.Rows {
+			changes = append(changes, newChange(nil, row))
+		}
+	case "DELETE":
+		for _, row := range rowsEvent.Rows {
+			changes = append(changes, newChange(row, nil))
+		}
+	case "UPDATE":
+		for i := 0; i+1 < len(rowsEvent.Rows); i += 2 {
+			changes = append(changes, newChange(rowsEvent.Rows[i], rowsEvent.Rows[i+1]))
+		}
+	}
+	return
+}
+
+// rowMap 将一行数据转换为 列名->值，row为nil时返回nil
+func (t *Table) rowMap(row []interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(t.Columns))
+	for i, col := range t.Columns {
+		if i < len(row) {
+			m[col] = t.plainValue(i, row[i])
+		}
+	}
+	return m
+}
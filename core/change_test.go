@@ -0,0 +1,61 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/shopspring/decimal"
+)
+
+func TestRowChangesFromRowsEvent(t *testing.T) {
+	tm := &replication.TableMapEvent{
+		Schema:           []byte("test"),
+		Table:            []byte("orders"),
+		ColumnCount:      4,
+		ColumnType:       []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_BLOB},
+		ColumnMeta:       []uint16{0, 10<<8 | 2, 4, 2},
+		ColumnName:       [][]byte{[]byte("id"), []byte("amount"), []byte("attrs"), []byte("data")},
+		PrimaryKey:       []uint64{0},
+		SignednessBitmap: []byte{0x80},
+	}
+	e := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.UPDATE_ROWS_EVENTv2, Timestamp: 1700000000, ServerID: 3, LogPos: 500, EventSize: 100},
+		Event: &replication.RowsEvent{Table: tm, TableID: 90, ColumnCount: 4, Rows: [][]interface{}{
+			{int32(-1), decimal.RequireFromString("1.5"), `{"a":1}`, []byte{0xff}},
+			{int32(-1), decimal.RequireFromString("2"), `{"a":2}`, nil},
+		}},
+	}
+	cfg := &conf.Config{}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	changes, err := RowChangesFromRowsEvent(e, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expect 1 change, got %d", len(changes))
+	}
+	b, err := json.Marshal(changes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"schema":"test","table":"orders","type":"UPDATE",` +
+		`"before":{"amount":"1.50","attrs":{"a":1},"data":"/w==","id":4294967295},` +
+		`"after":{"amount":"2.00","attrs":{"a":2},"data":null,"id":4294967295},` +
+		`"primary_key":{"id":4294967295},"file":"","start_pos":400,"end_pos":500,"timestamp":1700000000,"server_id":3}`
+	if string(b) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b, want)
+	}
+
+	cfg.Tables = nil
+	_ = cfg.Tables.Set("other")
+	if err := InitTableFilter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tableFilter = nil }()
+	if changes, _ = RowChangesFromRowsEvent(e, cfg); len(changes) != 0 {
+		t.Fatalf("expect table filtered, got %d changes", len(changes))
+	}
+}
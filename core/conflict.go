@@ -0,0 +1,116 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"fmt"
+	"io"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// ConflictDetector 记录回滚范围内修改过的行的主键（没有主键时为非空唯一索引），
+// 并检查停止位置之后的binlog是否再次修改了这些行，用于判断直接回滚是否安全
+type ConflictDetector struct {
+	// rows 为回滚范围内的行，值为停止位置之后对该行的修改
+	rows map[string][]laterChange
+	// conflicts 为被再次修改的行，按第一次修改的顺序
+	conflicts []string
+	// keyless 为回滚范围内无键表的行数，这些行无法检查
+	keyless int
+}
+
+type laterChange struct {
+	sqlType string
+	file    string
+	pos     uint32
+}
+
+func NewConflictDetector() *ConflictDetector {
+	return &ConflictDetector{rows: make(map[string][]laterChange)}
+}
+
+// rowKeys 返回事件中每行（UPDATE为修改前后的行）的 `db`.`t` WHERE `pk`=v 形式的键，无键表返回nil
+func rowKeys(e *replication.BinlogEvent, cfg *conf.Config) (keys []string, rows int, err error) {
+	rowsEvent, ok := e.Event.(*replication.RowsEvent)
+	if !ok {
+		return nil, 0, fmt.Errorf("event is not a RowsEvent")
+	}
+	if !matchTable(rowsEvent) {
+		return
+	}
+	t, err := eventTable(rowsEvent, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if t.WhereKeys = t.keyColumns(); t.WhereKeys == nil {
+		return nil, len(rowsEvent.Rows), nil
+	}
+	for _, row := range rowsEvent.Rows {
+		keys = append(keys, t.fullName()+" WHERE "+t.whereClause(row))
+	}
+	return keys, len(rowsEvent.Rows), nil
+}
+
+// AddRowsEvent 记录回滚范围内的RowsEvent修改的行，库表及 -sql-type 过滤条件与生成SQL时相同
+func (d *ConflictDetector) AddRowsEvent(e *replication.BinlogEvent, cfg *conf.Config) error {
+	if !cfg.SqlType.In(eventTypeToString(e.Header.EventType)) {
+		return nil
+	}
+	keys, rows, err := rowKeys(e, cfg)
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		d.keyless += rows
+	}
+	for _, key := range keys {
+		if _, ok := d.rows[key]; !ok {
+			d.rows[key] = nil
+		}
+	}
+	return nil
+}
+
+// CheckRowsEvent 检查停止位置之后的RowsEvent是否修改了回滚范围内的行
+func (d *ConflictDetector) CheckRowsEvent(e *replication.BinlogEvent, cfg *conf.Config, file string) error {
+	keys, _, err := rowKeys(e, cfg)
+	if err != nil {
+		return err
+	}
+	change := laterChange{sqlType: eventTypeToString(e.Header.EventType), file: file, pos: e.Header.LogPos - e.Header.EventSize}
+	for _, key := range keys {
+		changes, ok := d.rows[key]
+		if !ok {
+			continue
+		}
+		if changes == nil {
+			d.conflicts = append(d.conflicts, key)
+		}
+		// UPDATE修改前后的键相同时只记录一次
+		if n := len(changes); n == 0 || changes[n-1] != change {
+			d.rows[key] = append(changes, change)
+		}
+	}
+	return nil
+}
+
+// Report 输出停止位置之后被再次修改的行及汇总，每行最多列出3次修改
+func (d *ConflictDetector) Report(w io.Writer) error {
+	for _, key := range d.conflicts {
+		changes := d.rows[key]
+		line := fmt.Sprintf("#conflict %s changed later:", key)
+		for i, c := range changes {
+			if i == 3 {
+				line += fmt.Sprintf(" ... %d changes in total", len(changes))
+				break
+			}
+			line += fmt.Sprintf(" %s at %s:%d", c.sqlType, c.file, c.pos)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "#conflict summary: %d rows in rollback set, %d changed after the stop position, %d rows of tables without key not checked\n",
+		len(d.rows), len(d.conflicts), d.keyless)
+	return err
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"bytes"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestConflictDetector(t *testing.T) {
+	table := func(name string, pk []uint64) *replication.TableMapEvent {
+		return &replication.TableMapEvent{
+			Schema:      []byte("test"),
+			Table:       []byte(name),
+			ColumnCount: 2,
+			ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+			ColumnMeta:  []uint16{0, 40},
+			ColumnName:  [][]byte{[]byte("id"), []byte("name")},
+			PrimaryKey:  pk,
+		}
+	}
+	t1, t2 := table("t1", []uint64{0}), table("t2", nil)
+	event := func(tm *replication.TableMapEvent, tp replication.EventType, logPos uint32, rows ...[]interface{}) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: tp, LogPos: logPos, EventSize: 100},
+			Event:  &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 2, Rows: rows},
+		}
+	}
+	cfg := &conf.Config{}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	d := NewConflictDetector()
+	for _, e := range []*replication.BinlogEvent{
+		event(t1, replication.WRITE_ROWS_EVENTv2, 200, []interface{}{int32(1), "a"}, []interface{}{int32(2), "b"}),
+		event(t2, replication.WRITE_ROWS_EVENTv2, 300, []interface{}{int32(1), "a"}),
+	} {
+		if err := d.AddRowsEvent(e, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []*replication.BinlogEvent{
+		event(t1, replication.UPDATE_ROWS_EVENTv2, 500, []interface{}{int32(2), "b"}, []interface{}{int32(2), "c"}),
+		event(t1, replication.WRITE_ROWS_EVENTv2, 600, []interface{}{int32(3), "d"}),
+		event(t1, replication.DELETE_ROWS_EVENTv2, 700, []interface{}{int32(2), "c"}),
+	} {
+		if err := d.CheckRowsEvent(e, cfg, "mysql-bin.000002"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := d.Report(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "#conflict `test`.`t1` WHERE `id`=2 changed later: UPDATE at mysql-bin.000002:400 DELETE at mysql-bin.000002:600\n" +
+		"#conflict summary: 2 rows in rollback set, 1 changed after the stop position, 1 rows of tables without key not checked\n"
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -9,17 +9,94 @@ import (
 	"strings"
 )
 
-var cachedPks, cachedCol *Cache
+var cachedTables *Cache
+var tracker *SchemaTracker
 
 func init() {
-	if cachedCol == nil {
-		cachedCol = NewCache()
+	if cachedTables == nil {
+		cachedTables = NewCache()
 	}
-	if cachedPks == nil {
-		cachedPks = NewCache()
+	if tracker == nil {
+		tracker = NewSchemaTracker(loadTable)
 	}
 }
 
+// loadTable 加载表结构，优先使用 -schema-file，其次从 information_schema 查询
+func loadTable(schema, table string) (*Table, error) {
+	if t := loadTableFromFile(schema, table); t != nil {
+		return t, nil
+	}
+	if db.Conn == nil {
+		return nil, fmt.Errorf("no schema for table %s.%s: not found in -schema-file and no database connection", schema, table)
+	}
+	return loadTableFromDb(schema, table)
+}
+
+// loadTableFromDb 从 information_schema 加载表结构，得到的是当前的表结构，记录加载时的服务器时间
+func loadTableFromDb(schema, table string) (t *Table, err error) {
+	t = &Table{Schema: schema, Table: table}
+	if t.loadedAt, err = db.GetServerTime(); err != nil {
+		return
+	}
+	if t.Columns, err = db.GetColumns(schema, table); err != nil {
+		return
+	}
+	if t.Pks, err = db.GetPk(schema, table); err != nil {
+		return
+	}
+	if t.ColumnTypes, err = db.GetColumnTypes(schema, table); err != nil {
+		return
+	}
+	if t.Uks, err = db.GetUniqueKey(schema, table); err != nil {
+		return
+	}
+	t.AutoIncrement, err = db.GetAutoIncrement(schema, table)
+	return
+}
+
+// tableDefinition 优先使用TableMapEvent中记录的列信息(binlog_row_metadata=FULL)，
+// 不需要连接数据库，也不受表结构变更的影响；否则使用跟踪的表结构。
+// 元数据中没有唯一索引和自增列，没有主键(需要用唯一索引定位行)或 -noPK 时从跟踪的表结构中补充，
+// 没有可用的表结构时只按元数据解析
+func tableDefinition(re *replication.RowsEvent, conf *conf.Config) (*Table, error) {
+	return cachedTables.Get(re, func(re *replication.RowsEvent) (*Table, error) {
+		if len(re.Table.ColumnName) == 0 {
+			tracked, err := tracker.Get(string(re.Table.Schema), string(re.Table.Table))
+			if err != nil {
+				return nil, err
+			}
+			t := *tracked
+			t.setMeta(re.Table)
+			return &t, nil
+		}
+		t := tableFromTableMap(re.Table)
+		if len(t.Pks) == 0 || conf.NoPk {
+			if tracked, err := tracker.Get(t.Schema, t.Table); err == nil {
+				t.mergeKeys(tracked)
+			}
+		}
+		t.setMeta(re.Table)
+		return t, nil
+	})
+}
+
+// ApplyQueryEvent 将QueryEvent中的DDL应用到表结构跟踪中，表结构变化时清空按TableID的缓存。
+// 需要对所有读到的QueryEvent调用，不受过滤条件影响
+func ApplyQueryEvent(e *replication.BinlogEvent) error {
+	qe, ok := e.Event.(*replication.QueryEvent)
+	if !ok {
+		return fmt.Errorf("event is not a Query Event")
+	}
+	if utils.Contains([]string{"BEGIN", "COMMIT"}, string(qe.Query)) {
+		return nil
+	}
+	changed, err := tracker.ApplyDDL(string(qe.Schema), string(qe.Query), e.Header.Timestamp)
+	if changed {
+		cachedTables.Reset()
+	}
+	return err
+}
+
 func ConcatSqlFromQueryEvent(e *replication.BinlogEvent, cfg *conf.Config) (sql string, err error) {
 	qe, ok := e.Event.(*replication.QueryEvent)
 	if !ok {
@@ -30,8 +107,12 @@ func ConcatSqlFromQueryEvent(e *replication.BinlogEvent, cfg *conf.Config) (sql
 	if utils.Contains(ignoreQuery, string(qe.Query)) {
 		return
 	}
+	// 按语句涉及的库表过滤，与行事件的过滤条件相同
+	if !cfg.AllDDL && tableFilter != nil && !tableFilter.MatchQuery(string(qe.Schema), string(qe.Query)) {
+		return
+	}
 	if len(qe.Schema) != 0 {
-		sql = fmt.Sprintf("USE %s;", string(qe.Schema))
+		sql = fmt.Sprintf("USE %s;", QuoteIdent(string(qe.Schema)))
 	}
 	sql = fmt.Sprintf("%s\n%s;", sql, string(qe.Query))
 	return
@@ -43,10 +124,7 @@ func ConcatSqlFromRowsEvent(e *replication.BinlogEvent, cfg *conf.Config) (sql s
 		err = fmt.Errorf("event is not a RowsEvent")
 		return
 	}
-	if cfg.Databases.Len() != 0 && !cfg.Databases.In(string(rowsEvent.Table.Schema)) {
-		return
-	}
-	if cfg.Tables.Len() != 0 && !cfg.Tables.In(string(rowsEvent.Table.Table)) {
+	if !matchTable(rowsEvent) {
 		return
 	}
 	sql, err = genSqlStatement(e.Header.EventType, rowsEvent, cfg)
@@ -54,13 +132,37 @@ func ConcatSqlFromRowsEvent(e *replication.BinlogEvent, cfg *conf.Config) (sql s
 }
 
 type Table struct {
-	Schema, Table string
-	Columns, Pks  []string
-	TableId       uint64
+	Schema  string   `json:"schema"`
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	Pks     []string `json:"pks,omitempty"`
+	// Uks 为第一个所有列都是 NOT NULL 的唯一索引
+	Uks []string `json:"uks,omitempty"`
+	// AutoIncrement 为 AUTO_INCREMENT 列
+	AutoIncrement []string `json:"auto_increment,omitempty"`
+	// ColumnTypes 为 information_schema.columns.column_type，如 bigint(20) unsigned、enum('a','b')
+	ColumnTypes []string `json:"column_types,omitempty"`
+	// WhereKeys 为UPDATE/DELETE的WHERE条件使用的列，为空时使用整行数据
+	WhereKeys []string `json:"-"`
+	TableId   uint64   `json:"-"`
+	// Meta 为该表的TableMapEvent，用于按列类型渲染值
+	Meta *replication.TableMapEvent `json:"-"`
+	// NoBackslashEscapes 为true时按 NO_BACKSLASH_ESCAPES 模式转义字符串
+	NoBackslashEscapes bool `json:"-"`
+
+	// unsigned、collations、enums、sets 为从 Meta 中解析的列属性，缓存的表结构定义中预先生成，各事件的Table共用
+	unsigned   map[int]bool
+	collations map[int]uint64
+	enums      map[int][]string
+	sets       map[int][]string
+	// loadedAt 为从当前库加载表结构时的服务器时间，之前的DDL已包含在表结构中；为0表示来自 -schema-file 或DDL
+	loadedAt uint32
+	// autoIncrementUnknown 为true表示表结构只来自binlog元数据，不知道哪些是 AUTO_INCREMENT 列
+	autoIncrementUnknown bool
 }
 
 func NewTable(re *replication.RowsEvent) *Table {
-	return &Table{TableId: re.TableID, Schema: string(re.Table.Schema), Table: string(re.Table.Table)}
+	return &Table{TableId: re.TableID, Schema: string(re.Table.Schema), Table: string(re.Table.Table), Meta: re.Table}
 }
 
 func eventTypeToString(eventType replication.EventType) string {
@@ -78,14 +180,62 @@ func eventTypeToString(eventType replication.EventType) string {
 	}
 }
 
+// SchemaMismatchError 为binlog中的列数或列类型与跟踪的表结构不一致，通常是表结构不是起始位置时的结构。
+// 此时无法正确解析该表的行，需要停止解析而不是跳过这些行或错位解析
+type SchemaMismatchError struct {
+	Schema, Table  string
+	BinlogColumns  int
+	TrackedColumns int
+	// Column 不为空时为类型不一致的列，TrackedType 为跟踪的列类型，BinlogType 为TableMapEvent中的类型
+	Column      string
+	TrackedType string
+	BinlogType  byte
+}
+
+func (e *SchemaMismatchError) Error() string {
+	msg := fmt.Sprintf("table %s.%s has %d columns in binlog but %d in tracked schema", e.Schema, e.Table, e.BinlogColumns, e.TrackedColumns)
+	if e.Column != "" {
+		msg = fmt.Sprintf("table %s.%s column %s is %s in tracked schema but %s in binlog", e.Schema, e.Table, e.Column, e.TrackedType, binlogTypeName(e.BinlogType))
+	}
+	return msg + ", the tracked schema is not the one at this position: use -schema-file with the table definition at the start position, " +
+		"or set binlog_row_metadata=FULL on the source"
+}
+
+// eventTable 返回RowsEvent对应的表，包含表结构及TableMapEvent中的列类型。
+// 跟踪的表结构与TableMapEvent的列数或列类型不一致时返回 SchemaMismatchError
+func eventTable(rowsEvent *replication.RowsEvent, conf *conf.Config) (*Table, error) {
+	t := NewTable(rowsEvent)
+	t.NoBackslashEscapes = conf.NoBackslashEscapes
+	def, err := tableDefinition(rowsEvent, conf)
+	if err != nil {
+		return nil, err
+	}
+	if len(def.Columns) != int(rowsEvent.ColumnCount) {
+		return nil, &SchemaMismatchError{Schema: t.Schema, Table: t.Table, BinlogColumns: int(rowsEvent.ColumnCount), TrackedColumns: len(def.Columns)}
+	}
+	t.Columns, t.Pks, t.Uks, t.AutoIncrement, t.ColumnTypes = def.Columns, def.Pks, def.Uks, def.AutoIncrement, def.ColumnTypes
+	t.autoIncrementUnknown = def.autoIncrementUnknown
+	t.unsigned, t.collations, t.enums, t.sets = def.unsigned, def.collations, def.enums, def.sets
+	for i := range t.Columns {
+		if tp, _, ok := t.realType(i); ok && !columnTypeMatches(t.columnType(i), tp) {
+			return nil, &SchemaMismatchError{Schema: t.Schema, Table: t.Table, BinlogColumns: len(t.Columns), TrackedColumns: len(t.Columns),
+				Column: t.Columns[i], TrackedType: t.ColumnTypes[i], BinlogType: tp}
+		}
+	}
+	return t, nil
+}
+
 func genSqlStatement(eventType replication.EventType, rowsEvent *replication.RowsEvent, conf *conf.Config) (sql string, err error) {
 	var sqlList []string
-	t := NewTable(rowsEvent)
-	if t.Columns, err = cachedCol.Get(rowsEvent, db.GetColumns); err != nil {
+	t, err := eventTable(rowsEvent, conf)
+	if err != nil {
 		return
 	}
-	if t.Pks, err = cachedPks.Get(rowsEvent, db.GetPk); err != nil {
-		return
+	if conf.WhereMode != "full" {
+		if t.WhereKeys = t.keyColumns(); t.WhereKeys == nil && conf.WhereMode == "key" {
+			err = fmt.Errorf("table %s.%s has neither primary key nor not null unique key", t.Schema, t.Table)
+			return
+		}
 	}
 	if conf.Flashback {
 		switch eventTypeToString(eventType) {
@@ -93,23 +243,24 @@ func genSqlStatement(eventType replication.EventType, rowsEvent *replication.Row
 			if !conf.SqlType.In("DELETE") {
 				return "", nil
 			}
-			for _, row := range rowsEvent.Rows {
-				insertSql := generateInsertSql(t, row)
+			// 回滚时同一事件内的行也需要从后往前处理
+			for i := len(rowsEvent.Rows) - 1; i >= 0; i-- {
+				insertSql := generateInsertSql(t, rowsEvent.Rows[i])
 				sqlList = append(sqlList, insertSql)
 			}
 		case "INSERT":
 			if !conf.SqlType.In("INSERT") {
 				return "", nil
 			}
-			for _, row := range rowsEvent.Rows {
-				delSql := generateDeleteSql(t, row)
+			for i := len(rowsEvent.Rows) - 1; i >= 0; i-- {
+				delSql := generateDeleteSql(t, rowsEvent.Rows[i])
 				sqlList = append(sqlList, delSql)
 			}
 		case "UPDATE":
 			if !conf.SqlType.In("UPDATE") {
 				return "", nil
 			}
-			for i := 0; i < len(rowsEvent.Rows); i = i + 2 {
+			for i := len(rowsEvent.Rows) - 2; i >= 0; i = i - 2 {
 				updateSql := ""
 				if conf.Simple {
 					updateSql = genSimpleUpdateSql(t, rowsEvent.Rows[i+1], rowsEvent.Rows[i])
@@ -134,7 +285,12 @@ func genSqlStatement(eventType replication.EventType, rowsEvent *replication.Row
 				return "", nil
 			}
 			for _, row := range rowsEvent.Rows {
-				insertSql := generateInsertSql(t, row)
+				insertSql := ""
+				if conf.NoPk {
+					insertSql = genNoPkInsertSql(t, row)
+				} else {
+					insertSql = generateInsertSql(t, row)
+				}
 				sqlList = append(sqlList, insertSql)
 			}
 		case "UPDATE":
@@ -158,62 +314,28 @@ func genSqlStatement(eventType replication.EventType, rowsEvent *replication.Row
 
 func generateInsertSql(t *Table, row []interface{}) string {
 	var valueString []string
-	for _, r := range row {
-		switch val := r.(type) {
-		case string:
-			valueString = append(valueString, fmt.Sprintf("'%v'", val))
-		case nil:
-			valueString = append(valueString, "NULL")
-		default:
-			valueString = append(valueString, fmt.Sprintf("%v", val))
-		}
+	for i, r := range row {
+		valueString = append(valueString, t.formatValue(i, r))
 	}
-	// INSERT INTO test.t(id,a,b,c,d,e,f) VALUES(1,"hello",23.5,true,NULL,"")
-	return fmt.Sprintf(`INSERT INTO %s.%s(%s) VALUES(%s);`, t.Schema, t.Table, strings.Join(t.Columns, ","), strings.Join(valueString, ","))
+	// INSERT INTO `test`.`t`(`id`,`a`,`b`,`c`,`d`,`e`,`f`) VALUES(1,'hello',23.5,true,NULL,'')
+	return fmt.Sprintf(`INSERT INTO %s(%s) VALUES(%s);`, t.fullName(), t.quoteColumns(t.Columns), strings.Join(valueString, ","))
 }
 
 func generateDeleteSql(t *Table, row []interface{}) string {
-	var condition []string
-	for i, col := range t.Columns {
-		switch val := row[i].(type) {
-		case string:
-			condition = append(condition, fmt.Sprintf("%s='%v'", col, val))
-		case nil:
-			condition = append(condition, fmt.Sprintf("%s IS NULL", col))
-		default:
-			condition = append(condition, fmt.Sprintf("%s=%v", col, val))
-		}
-	}
-	return fmt.Sprintf("DELETE FROM %s.%s WHERE %s LIMIT 1;", t.Schema, t.Table, strings.Join(condition, " AND "))
+	return fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT 1;", t.fullName(), t.whereClause(row))
 }
 
 func generateUpdateSql(t *Table, oldValue []interface{}, newValue []interface{}) string {
-	// UPDATE test.t SET id=1,a="hello",b=true,c=23.4,d=NULL,f="" WHERE id=1 AND a = 'world' AND b=true AND c=23.4 AND d IS NULL ADN f=''
-	var condition []string
+	// UPDATE `test`.`t` SET `id`=1,`a`='hello',`b`=true,`c`=23.4,`d`=NULL,`f`='' WHERE `id`=1 AND `a`='world' AND `b`=true AND `c`=23.4 AND `d` IS NULL AND `f`=''
 	var setString []string
-	for i, col := range t.Columns {
-		switch oval := oldValue[i].(type) {
-		case string:
-			condition = append(condition, fmt.Sprintf("%s='%v'", col, oval))
-		case nil:
-			condition = append(condition, fmt.Sprintf("%s IS NULL", col))
-		default:
-			condition = append(condition, fmt.Sprintf("%s=%v", col, oval))
-		}
-		switch nval := newValue[i].(type) {
-		case string:
-			setString = append(setString, fmt.Sprintf("%s='%v'", col, nval))
-		case nil:
-			setString = append(setString, fmt.Sprintf("%s=NULL", col))
-		default:
-			setString = append(setString, fmt.Sprintf("%s=%v", col, nval))
-		}
+	for i := range t.Columns {
+		setString = append(setString, t.assignment(i, newValue[i]))
 	}
-	return fmt.Sprintf("UPDATE %s.%s SET %s WHERE %s LIMIT 1;", t.Schema, t.Table, strings.Join(setString, ","), strings.Join(condition, " AND "))
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s LIMIT 1;", t.fullName(), strings.Join(setString, ","), t.whereClause(oldValue))
 }
 
 func genSimpleUpdateSql(t *Table, oldValue []interface{}, newValue []interface{}) string {
-	// UPDATE test.t SET id=1,a="hello",b=true,c=23.4,d=NULL,f="" WHERE id=1 AND a = 'world' AND b=true AND c=23.4 AND d IS NULL ADN f=''
+	// UPDATE `test`.`t` SET `a`='hello' WHERE `id`=1 AND `a`='world'
 	var condition []string
 	var setString []string
 
@@ -221,45 +343,41 @@ func genSimpleUpdateSql(t *Table, oldValue []interface{}, newValue []interface{}
 		if !utils.Contains(t.Pks, col) && fmt.Sprintf("%v", oldValue[i]) == fmt.Sprintf("%v", newValue[i]) {
 			continue
 		}
-		switch oval := oldValue[i].(type) {
-		case string:
-			condition = append(condition, fmt.Sprintf("%s='%v'", col, oval))
-		case nil:
-			condition = append(condition, fmt.Sprintf("%s IS NULL", col))
-		default:
-			condition = append(condition, fmt.Sprintf("%s=%v", col, oval))
-		}
-		switch nval := newValue[i].(type) {
-		case string:
-			setString = append(setString, fmt.Sprintf("%s='%v'", col, nval))
-		case nil:
-			setString = append(setString, fmt.Sprintf("%s=NULL", col))
-		default:
-			setString = append(setString, fmt.Sprintf("%s=%v", col, nval))
-		}
+		condition = append(condition, t.condition(i, oldValue[i]))
+		setString = append(setString, t.assignment(i, newValue[i]))
 	}
-	return fmt.Sprintf("UPDATE %s.%s SET %s WHERE %s LIMIT 1;", t.Schema, t.Table, strings.Join(setString, ","), strings.Join(condition, " AND "))
+	where := strings.Join(condition, " AND ")
+	if len(t.WhereKeys) != 0 {
+		where = t.whereClause(oldValue)
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s LIMIT 1;", t.fullName(), strings.Join(setString, ","), where)
 }
 
+// noPkColumns 返回 -noPK 时INSERT中省略的列，即 AUTO_INCREMENT 列。
+// 只有binlog元数据时不知道自增列，主键只有一个整数列时视为自增主键，其他主键(如联合主键)都保留
+func (t *Table) noPkColumns() []string {
+	if !t.autoIncrementUnknown {
+		return t.AutoIncrement
+	}
+	if len(t.Pks) == 1 && t.isInteger(t.indexOf(t.Pks[0])) {
+		return t.Pks
+	}
+	return nil
+}
+
+// genNoPkInsertSql 生成不带 AUTO_INCREMENT 列的INSERT语句，由目标库重新分配自增值
 func genNoPkInsertSql(t *Table, rows []interface{}) string {
 	pkMap := make(map[string]bool)
-	for _, pk := range t.Pks {
-		pkMap[pk] = true
+	for _, col := range t.noPkColumns() {
+		pkMap[col] = true
 	}
 	var columnsRes []string
 	var valueString []string
 	for i := 0; i < len(t.Columns); i++ {
 		if !pkMap[t.Columns[i]] {
 			columnsRes = append(columnsRes, t.Columns[i])
-			switch val := rows[i].(type) {
-			case string:
-				valueString = append(valueString, fmt.Sprintf("'%v'", val))
-			case nil:
-				valueString = append(valueString, "NULL")
-			default:
-				valueString = append(valueString, fmt.Sprintf("%v", val))
-			}
+			valueString = append(valueString, t.formatValue(i, rows[i]))
 		}
 	}
-	return fmt.Sprintf(`INSERT INTO %s.%s(%s) VALUES(%s);`, t.Schema, t.Table, strings.Join(columnsRes, ","), strings.Join(valueString, ","))
+	return fmt.Sprintf(`INSERT INTO %s(%s) VALUES(%s);`, t.fullName(), t.quoteColumns(columnsRes), strings.Join(valueString, ","))
 }
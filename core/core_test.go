@@ -99,19 +99,48 @@ func Test_genNoPkInsertSql(t *testing.T) {
 	//old_value := []interface{}{1, "world", "", "NULL", 22.35, true, nil}
 	val := []interface{}{1, "hello", "", "NULL", 22.35, true, nil}
 	tab := &Table{
-		Schema:  "test",
-		Table:   "t",
-		Columns: []string{"id", "a", "b", "c", "d", "f", "ff"},
-		Pks:     []string{"id"},
-		TableId: 100,
+		Schema:        "test",
+		Table:         "t",
+		Columns:       []string{"id", "a", "b", "c", "d", "f", "ff"},
+		Pks:           []string{"id"},
+		AutoIncrement: []string{"id"},
+		TableId:       100,
 	}
 	sql := genNoPkInsertSql(tab, val)
-	if sql != "INSERT INTO test.t(a,b,c,d,f,ff) VALUES('hello','','NULL',22.35,true,NULL);" {
+	if sql != "INSERT INTO `test`.`t`(`a`,`b`,`c`,`d`,`f`,`ff`) VALUES('hello','','NULL',22.35,true,NULL);" {
+		log.Fatal(sql)
+	}
+	// 没有自增列的主键(自然主键、联合主键)需要保留
+	tab.Pks, tab.AutoIncrement = []string{"id", "ff"}, nil
+	sql = genNoPkInsertSql(tab, val)
+	if sql != "INSERT INTO `test`.`t`(`id`,`a`,`b`,`c`,`d`,`f`,`ff`) VALUES(1,'hello','','NULL',22.35,true,NULL);" {
 		log.Fatal(sql)
 	}
-	tab.Pks = []string{"id", "ff"}
+	tab.Pks = []string{"id"}
 	sql = genNoPkInsertSql(tab, val)
-	if sql != "INSERT INTO test.t(a,b,c,d,f) VALUES('hello','','NULL',22.35,true);" {
+	if sql != "INSERT INTO `test`.`t`(`id`,`a`,`b`,`c`,`d`,`f`,`ff`) VALUES(1,'hello','','NULL',22.35,true,NULL);" {
 		log.Fatal(sql)
 	}
+	tab.Pks = nil
+	tab.AutoIncrement = []string{"id"}
+	sql = genNoPkInsertSql(tab, val)
+	if sql != "INSERT INTO `test`.`t`(`a`,`b`,`c`,`d`,`f`,`ff`) VALUES('hello','','NULL',22.35,true,NULL);" {
+		log.Fatal(sql)
+	}
+
+	// 只有binlog元数据时，只有一个整数列的主键视为自增主键
+	tab.AutoIncrement, tab.autoIncrementUnknown = nil, true
+	tab.ColumnTypes = []string{"int(11)", "varchar(10)", "varchar(10)", "varchar(10)", "decimal(10,2)", "tinyint(1)", "int(11)"}
+	tab.Pks = []string{"id"}
+	sql = genNoPkInsertSql(tab, val)
+	if sql != "INSERT INTO `test`.`t`(`a`,`b`,`c`,`d`,`f`,`ff`) VALUES('hello','','NULL',22.35,true,NULL);" {
+		log.Fatal(sql)
+	}
+	for _, pks := range [][]string{{"id", "ff"}, {"a"}} {
+		tab.Pks = pks
+		sql = genNoPkInsertSql(tab, val)
+		if sql != "INSERT INTO `test`.`t`(`id`,`a`,`b`,`c`,`d`,`f`,`ff`) VALUES(1,'hello','','NULL',22.35,true,NULL);" {
+			log.Fatal(sql)
+		}
+	}
 }
@@ -0,0 +1,58 @@
+package core
+
+import "time"
+
+// DebeziumEnvelope 为 Debezium MySQL connector 的变更事件格式，-output-format debezium 时每行输出一个
+type DebeziumEnvelope struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source DebeziumSource         `json:"source"`
+	// Op 为 c(INSERT)、u(UPDATE)、d(DELETE)
+	Op string `json:"op"`
+	// TsMs 为生成该事件的时间
+	TsMs int64 `json:"ts_ms"`
+}
+
+// DebeziumSource 为事件来源信息，Pos 为事件的起始位点，Row 为行在事件中的序号
+type DebeziumSource struct {
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	Db        string  `json:"db"`
+	Table     string  `json:"table"`
+	ServerId  uint32  `json:"server_id"`
+	Gtid      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Row       int     `json:"row"`
+}
+
+var debeziumOps = map[string]string{"INSERT": "c", "UPDATE": "u", "DELETE": "d"}
+
+// Debezium 将行变更转换为Debezium格式，now 为输出时间
+func (c *RowChange) Debezium(now time.Time) *DebeziumEnvelope {
+	d := &DebeziumEnvelope{
+		Before: c.Before,
+		After:  c.After,
+		Source: DebeziumSource{
+			Connector: "mysql",
+			Name:      "binlog2sql_go",
+			TsMs:      int64(c.Timestamp) * 1000,
+			Snapshot:  "false",
+			Db:        c.Schema,
+			Table:     c.Table,
+			ServerId:  c.ServerId,
+			File:      c.File,
+			Pos:       c.StartPos,
+			Row:       c.Row,
+		},
+		Op:   debeziumOps[c.Type],
+		TsMs: now.UnixMilli(),
+	}
+	if c.Gtid != "" {
+		gtid := c.Gtid
+		d.Source.Gtid = &gtid
+	}
+	return d
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRowChangeDebezium(t *testing.T) {
+	c := &RowChange{
+		Schema: "test", Table: "t", Type: "DELETE",
+		Before:   map[string]interface{}{"id": 1},
+		File:     "mysql-bin.000003",
+		StartPos: 400, EndPos: 500, Timestamp: 1700000000, ServerId: 3, Row: 2,
+	}
+	b, err := json.Marshal(c.Debezium(time.UnixMilli(1700000001234)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"before":{"id":1},"after":null,"source":{"connector":"mysql","name":"binlog2sql_go","ts_ms":1700000000000,` +
+		`"snapshot":"false","db":"test","table":"t","server_id":3,"gtid":null,"file":"mysql-bin.000003","pos":400,"row":2},` +
+		`"op":"d","ts_ms":1700000001234}`
+	if string(b) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b, want)
+	}
+	c.Gtid = "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+	if d := c.Debezium(time.Now()); d.Source.Gtid == nil || *d.Source.Gtid != c.Gtid {
+		t.Fatal("expect gtid in source")
+	}
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+// namePattern 为库名或表名的匹配模式：以 ~ 开头为正则表达式(匹配整个名字)，否则为通配符(* ? [...])
+type namePattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func newNamePattern(s string) (p namePattern, err error) {
+	if strings.HasPrefix(s, "~") {
+		p.re, err = regexp.Compile("^(?:" + s[1:] + ")$")
+		return
+	}
+	if _, err = path.Match(s, ""); err != nil {
+		return p, fmt.Errorf("invalid pattern %q: %v", s, err)
+	}
+	p.glob = s
+	return
+}
+
+func (p namePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// tablePattern 为表的匹配模式，schema 为nil时匹配所有库中的表
+type tablePattern struct {
+	schema *namePattern
+	table  namePattern
+}
+
+// newTablePattern 解析 表 或 库.表 形式的模式，名字中的 . 需要写为 \.
+func newTablePattern(s string) (p tablePattern, err error) {
+	table := s
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == '.' {
+			schema, err := newNamePattern(s[:i])
+			if err != nil {
+				return p, err
+			}
+			p.schema, table = &schema, s[i+1:]
+			break
+		}
+	}
+	p.table, err = newNamePattern(table)
+	return
+}
+
+func (p tablePattern) match(schema, table string) bool {
+	return (p.schema == nil || p.schema.match(schema)) && p.table.match(table)
+}
+
+// TableFilter 为 -databases/-tables/-ignore-databases/-ignore-tables 的库表过滤条件，
+// 库和表都要满足包含条件(为空时不限制)，且不满足任一排除条件
+type TableFilter struct {
+	databases       []namePattern
+	tables          []tablePattern
+	ignoreDatabases []namePattern
+	ignoreTables    []tablePattern
+}
+
+func NewTableFilter(databases, tables, ignoreDatabases, ignoreTables []string) (*TableFilter, error) {
+	f := &TableFilter{}
+	for _, l := range []struct {
+		patterns []string
+		dst      *[]namePattern
+	}{{databases, &f.databases}, {ignoreDatabases, &f.ignoreDatabases}} {
+		for _, s := range l.patterns {
+			p, err := newNamePattern(s)
+			if err != nil {
+				return nil, err
+			}
+			*l.dst = append(*l.dst, p)
+		}
+	}
+	for _, l := range []struct {
+		patterns []string
+		dst      *[]tablePattern
+	}{{tables, &f.tables}, {ignoreTables, &f.ignoreTables}} {
+		for _, s := range l.patterns {
+			p, err := newTablePattern(s)
+			if err != nil {
+				return nil, err
+			}
+			*l.dst = append(*l.dst, p)
+		}
+	}
+	return f, nil
+}
+
+// tableFilter 为启动时按配置编译好的库表过滤条件，为nil时不过滤
+var tableFilter *TableFilter
+
+// InitTableFilter 按 cfg 编译库表过滤条件，需要在解析binlog前调用
+func InitTableFilter(cfg *conf.Config) error {
+	f, err := NewTableFilter(cfg.Databases, cfg.Tables, cfg.IgnoreDatabases, cfg.IgnoreTables)
+	if err != nil {
+		return err
+	}
+	tableFilter = f
+	return nil
+}
+
+// matchTable 判断RowsEvent的表是否满足库表过滤条件
+func matchTable(re *replication.RowsEvent) bool {
+	return tableFilter == nil || tableFilter.Match(string(re.Table.Schema), string(re.Table.Table))
+}
+
+// MatchSchema 判断库是否满足 -databases/-ignore-databases
+func (f *TableFilter) MatchSchema(schema string) bool {
+	if len(f.databases) != 0 && !matchAny(f.databases, schema) {
+		return false
+	}
+	return !matchAny(f.ignoreDatabases, schema)
+}
+
+// Match 判断表是否满足过滤条件
+func (f *TableFilter) Match(schema, table string) bool {
+	if !f.MatchSchema(schema) {
+		return false
+	}
+	if len(f.tables) != 0 && !matchAnyTable(f.tables, schema, table) {
+		return false
+	}
+	return !matchAnyTable(f.ignoreTables, schema, table)
+}
+
+// MatchQuery 判断语句是否涉及满足过滤条件的库表，schema为语句执行时的默认库。
+// 涉及多张表时有一张满足即可，库级DDL按库过滤，不涉及库表的语句按默认库过滤，无法解析的语句不过滤
+func (f *TableFilter) MatchQuery(schema, query string) bool {
+	tables, databases, err := queryObjects(schema, query)
+	if err != nil {
+		return true
+	}
+	for _, db := range databases {
+		if f.MatchSchema(db) {
+			return true
+		}
+	}
+	for _, t := range tables {
+		if f.Match(t[0], t[1]) {
+			return true
+		}
+	}
+	if len(tables) != 0 || len(databases) != 0 {
+		return false
+	}
+	return schema == "" || f.MatchSchema(schema)
+}
+
+func matchAny(patterns []namePattern, name string) bool {
+	for _, p := range patterns {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyTable(patterns []tablePattern, schema, table string) bool {
+	for _, p := range patterns {
+		if p.match(schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableNameCollector 收集语句中出现的所有表名
+type tableNameCollector struct {
+	names []*ast.TableName
+}
+
+func (c *tableNameCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if tn, ok := n.(*ast.TableName); ok {
+		c.names = append(c.names, tn)
+	}
+	return n, false
+}
+
+func (c *tableNameCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// queryObjects 解析语句，返回被修改的表(库名, 表名)及库级DDL操作的库。
+// DDL只取被修改的表，CREATE TABLE ... LIKE 的源表、外键引用的表、视图查询的表不算在内；
+// 其他语句(如 STATEMENT 格式的DML)取语句中出现的所有表
+func queryObjects(schema, query string) (tables [][2]string, databases []string, err error) {
+	stmts, _, err := parser.New().Parse(query, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	add := func(names ...*ast.TableName) {
+		for _, tn := range names {
+			tables = append(tables, [2]string{schemaOf(schema, tn), tn.Name.O})
+		}
+	}
+	for _, stmt := range stmts {
+		switch st := stmt.(type) {
+		case *ast.CreateDatabaseStmt:
+			databases = append(databases, st.Name)
+		case *ast.AlterDatabaseStmt:
+			if st.Name == "" {
+				databases = append(databases, schema)
+			} else {
+				databases = append(databases, st.Name)
+			}
+		case *ast.DropDatabaseStmt:
+			databases = append(databases, st.Name)
+		case *ast.CreateTableStmt:
+			add(st.Table)
+		case *ast.AlterTableStmt:
+			add(st.Table)
+			for _, spec := range st.Specs {
+				if spec.Tp == ast.AlterTableRenameTable {
+					add(spec.NewTable)
+				}
+			}
+		case *ast.RenameTableStmt:
+			for _, t2t := range st.TableToTables {
+				add(t2t.OldTable, t2t.NewTable)
+			}
+		case *ast.DropTableStmt:
+			add(st.Tables...)
+		case *ast.TruncateTableStmt:
+			add(st.Table)
+		case *ast.CreateIndexStmt:
+			add(st.Table)
+		case *ast.DropIndexStmt:
+			add(st.Table)
+		case *ast.CreateViewStmt:
+			add(st.ViewName)
+		default:
+			c := &tableNameCollector{}
+			stmt.Accept(c)
+			add(c.names...)
+		}
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestTableFilter(t *testing.T) {
+	f, err := NewTableFilter(
+		[]string{"shop_*", "~log_\\d+", "crm"},
+		[]string{"users", "crm.~cust.*", "shop_?.order[sx]", "a\\.b.t"},
+		[]string{"shop_test"},
+		[]string{"log_*.debug"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		schema, table string
+		want          bool
+	}{
+		{"shop_1", "users", true},
+		{"shop_1", "orders", true},
+		{"shop_12", "orders", false},
+		{"shop_test", "users", false},
+		{"log_7", "users", true},
+		{"log_7", "debug", false},
+		{"log_x", "users", false},
+		{"crm", "customers", true},
+		{"crm", "users", true},
+		{"crm", "orders", false},
+		{"other", "users", false},
+	}
+	for _, c := range cases {
+		if got := f.Match(c.schema, c.table); got != c.want {
+			t.Errorf("%s.%s: got %v, want %v", c.schema, c.table, got, c.want)
+		}
+	}
+	// 名字中的 . 需要转义
+	f, _ = NewTableFilter(nil, []string{"a\\.b.t"}, nil, nil)
+	if !f.Match("a.b", "t") || f.Match("a", "b.t") {
+		t.Error("escaped dot in db.table pattern")
+	}
+	for _, p := range []string{"[a", "~(a"} {
+		if _, err := NewTableFilter(nil, []string{p}, nil, nil); err == nil {
+			t.Errorf("%s: expect error", p)
+		}
+	}
+}
+
+func TestConcatSqlFromQueryEventFilter(t *testing.T) {
+	cfg := &conf.Config{}
+	_ = cfg.Databases.Set("shop,crm")
+	_ = cfg.Tables.Set("shop.orders,crm.*")
+	_ = cfg.IgnoreTables.Set("crm.tmp_*")
+	if err := InitTableFilter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tableFilter = nil }()
+	cases := []struct {
+		schema, query string
+		want          bool
+	}{
+		{"shop", "ALTER TABLE orders ADD COLUMN note text", true},
+		{"crm", "ALTER TABLE shop.orders ADD COLUMN note text", true},
+		{"shop", "ALTER TABLE users ADD COLUMN note text", false},
+		{"shop", "ALTER TABLE users RENAME TO orders", true},
+		// 多张表时有一张被选中即输出
+		{"shop", "RENAME TABLE orders TO orders_old, orders_new TO orders", true},
+		{"shop", "RENAME TABLE users TO crm.users", true},
+		{"shop", "RENAME TABLE users TO users_old", false},
+		{"shop", "DROP TABLE users, orders", true},
+		{"crm", "DROP TABLE tmp_1", false},
+		// 只看被修改的表，LIKE 的源表及外键引用的表不算
+		{"shop", "CREATE TABLE orders_copy LIKE orders", false},
+		{"shop", "CREATE TABLE orders LIKE users", true},
+		{"shop", "CREATE TABLE items (id int, order_id int, FOREIGN KEY (order_id) REFERENCES orders (id))", false},
+		{"shop", "CREATE TABLE crm.contacts (id int, order_id int, FOREIGN KEY (order_id) REFERENCES shop.users (id))", true},
+		{"shop", "CREATE INDEX idx ON orders (id)", true},
+		{"shop", "TRUNCATE TABLE users", false},
+		// 库级DDL及不涉及表的语句按库过滤
+		{"", "CREATE DATABASE crm", true},
+		{"", "DROP DATABASE other", false},
+		{"other", "CREATE USER u", false},
+		{"shop", "FLUSH PRIVILEGES", true},
+		// STATEMENT 格式的DML按语句中出现的表过滤
+		{"shop", "INSERT INTO orders SELECT * FROM users", true},
+		{"shop", "UPDATE users SET a = 1", false},
+		// 无法解析的语句不过滤
+		{"other", "CREATE TABLE t (id int) SOME_UNKNOWN_OPTION", true},
+	}
+	e := func(schema, query string) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.QUERY_EVENT},
+			Event:  &replication.QueryEvent{Schema: []byte(schema), Query: []byte(query)},
+		}
+	}
+	for _, c := range cases {
+		sql, err := ConcatSqlFromQueryEvent(e(c.schema, c.query), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sql != ""; got != c.want {
+			t.Errorf("%s: got %q, want output %v", c.query, sql, c.want)
+		}
+	}
+	cfg.AllDDL = true
+	if sql, _ := ConcatSqlFromQueryEvent(e("shop", "ALTER TABLE users ADD COLUMN note text"), cfg); sql != "USE `shop`;\nALTER TABLE users ADD COLUMN note text;" {
+		t.Fatalf("expect DDL of all tables with -all-ddl, got %q", sql)
+	}
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// FlashbackEntry 为一条回滚SQL及其在binlog中的起始位置，用于 -apply-to 记录回滚进度
+type FlashbackEntry struct {
+	File string
+	Pos  uint32
+	Gtid string
+	SQL  string
+}
+
+// FlashbackBuffer 缓存回滚SQL，内存中超过 limit 条后落盘到临时文件，
+// 最终按从新到旧的顺序输出，保证跨行、跨事件、跨binlog文件的回滚顺序正确
+type FlashbackBuffer struct {
+	limit int
+	items []FlashbackEntry
+	files []string
+}
+
+func NewFlashbackBuffer(limit int) *FlashbackBuffer {
+	if limit <= 0 {
+		limit = 10000
+	}
+	return &FlashbackBuffer{limit: limit}
+}
+
+// Append 追加一条（可以是多行的）SQL，按binlog顺序调用
+func (b *FlashbackBuffer) Append(sql string) error {
+	return b.AppendEntry(FlashbackEntry{SQL: sql})
+}
+
+// AppendEntry 追加一条带binlog位置的回滚SQL，按binlog顺序调用
+func (b *FlashbackBuffer) AppendEntry(entry FlashbackEntry) error {
+	b.items = append(b.items, entry)
+	if len(b.items) >= b.limit {
+		return b.spill()
+	}
+	return nil
+}
+
+func (b *FlashbackBuffer) Len() int {
+	return len(b.items)
+}
+
+// chunkWriter 写入落盘的记录，整数为4字节，字符串为4字节长度+内容，出错后之后的写入都被忽略
+type chunkWriter struct {
+	w   *bufio.Writer
+	buf [4]byte
+	err error
+}
+
+func (c *chunkWriter) uint32(v uint32) {
+	if c.err == nil {
+		binary.LittleEndian.PutUint32(c.buf[:], v)
+		_, c.err = c.w.Write(c.buf[:])
+	}
+}
+
+func (c *chunkWriter) string(s string) {
+	c.uint32(uint32(len(s)))
+	if c.err == nil {
+		_, c.err = c.w.WriteString(s)
+	}
+}
+
+// chunkReader 读取 chunkWriter 写入的记录，出错后之后读到的都是零值
+type chunkReader struct {
+	r   *bufio.Reader
+	buf [4]byte
+	err error
+}
+
+func (c *chunkReader) uint32() uint32 {
+	if c.err != nil {
+		return 0
+	}
+	if _, c.err = io.ReadFull(c.r, c.buf[:]); c.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(c.buf[:])
+}
+
+func (c *chunkReader) string() string {
+	n := c.uint32()
+	if c.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, c.err = io.ReadFull(c.r, buf); c.err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// unexpectedEOF 返回读取一条记录中间时的错误，记录不完整时为 io.ErrUnexpectedEOF
+func (c *chunkReader) unexpectedEOF() error {
+	if c.err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return c.err
+}
+
+// spill 将内存中的SQL写入临时文件，每条记录依次为 File、Gtid、SQL 和 Pos
+func (b *FlashbackBuffer) spill() error {
+	f, err := os.CreateTemp("", "binlog2sql_go_flashback_*")
+	if err != nil {
+		return err
+	}
+	b.files = append(b.files, f.Name())
+	w := &chunkWriter{w: bufio.NewWriter(f)}
+	for _, item := range b.items {
+		w.string(item.File)
+		w.string(item.Gtid)
+		w.string(item.SQL)
+		w.uint32(item.Pos)
+	}
+	err = w.err
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	b.items = b.items[:0]
+	return err
+}
+
+func readChunk(name string) (items []FlashbackEntry, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &chunkReader{r: bufio.NewReader(f)}
+	for {
+		var item FlashbackEntry
+		if item.File = r.string(); r.err == io.EOF {
+			return items, nil
+		}
+		item.Gtid = r.string()
+		item.SQL = r.string()
+		item.Pos = r.uint32()
+		if err = r.unexpectedEOF(); err != nil {
+			return
+		}
+		items = append(items, item)
+	}
+}
+
+// Each 按从新到旧的顺序遍历所有缓存的SQL
+func (b *FlashbackBuffer) Each(fn func(entry FlashbackEntry) error) error {
+	each := func(items []FlashbackEntry) error {
+		for i := len(items) - 1; i >= 0; i-- {
+			if err := fn(items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := each(b.items); err != nil {
+		return err
+	}
+	for i := len(b.files) - 1; i >= 0; i-- {
+		items, err := readChunk(b.files[i])
+		if err != nil {
+			return err
+		}
+		if err = each(items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTo 按从新到旧的顺序输出所有缓存的SQL，每条后追加换行
+func (b *FlashbackBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	err = b.Each(func(entry FlashbackEntry) error {
+		m, err := io.WriteString(w, entry.SQL+"\n")
+		n += int64(m)
+		return err
+	})
+	return
+}
+
+// Close 清理落盘的临时文件
+func (b *FlashbackBuffer) Close() error {
+	var err error
+	for _, name := range b.files {
+		if rerr := os.Remove(name); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	b.files = nil
+	b.items = nil
+	return err
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlashbackBuffer(t *testing.T) {
+	buf := NewFlashbackBuffer(3)
+	defer buf.Close()
+	var want []string
+	for i := 0; i < 10; i++ {
+		sql := fmt.Sprintf("DELETE FROM t WHERE id=%d;\nDELETE FROM t WHERE id=%d;", i, i+100)
+		if err := buf.Append(sql); err != nil {
+			t.Fatal(err)
+		}
+		want = append([]string{sql}, want...)
+	}
+	if len(buf.files) != 3 {
+		t.Fatalf("expect 3 spilled files, got %d", len(buf.files))
+	}
+	var out bytes.Buffer
+	if _, err := buf.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != strings.Join(want, "\n")+"\n" {
+		t.Fatal(out.String())
+	}
+}
+
+func TestFlashbackBufferEntries(t *testing.T) {
+	buf := NewFlashbackBuffer(2)
+	defer buf.Close()
+	var want []FlashbackEntry
+	for i := 0; i < 5; i++ {
+		entry := FlashbackEntry{File: "mysql-bin.000001", Pos: uint32(100 * i), Gtid: fmt.Sprintf("g:%d", i), SQL: fmt.Sprintf("DELETE FROM t WHERE id=%d;", i)}
+		if err := buf.AppendEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+		want = append([]FlashbackEntry{entry}, want...)
+	}
+	var got []FlashbackEntry
+	if err := buf.Each(func(entry FlashbackEntry) error {
+		got = append(got, entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QuoteIdent 用反引号包裹库名、表名、列名，名称中的反引号会被转义为两个反引号
+func QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// QuoteString 按MySQL的规则转义字符串并用单引号包裹。
+// noBackslashEscapes 对应 sql_mode 中的 NO_BACKSLASH_ESCAPES，此时反斜杠不是转义符，只能通过双写单引号转义
+func QuoteString(s string, noBackslashEscapes bool) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	if noBackslashEscapes {
+		b.WriteString(strings.ReplaceAll(s, "'", "''"))
+		b.WriteByte('\'')
+		return b.String()
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\x1a':
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// fullName 返回带引号的 `schema`.`table`
+func (t *Table) fullName() string {
+	return QuoteIdent(t.Schema) + "." + QuoteIdent(t.Table)
+}
+
+func (t *Table) quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = QuoteIdent(col)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// condition 生成WHERE条件中的 `col`=value 或 `col` IS NULL
+func (t *Table) condition(i int, v interface{}) string {
+	if v == nil {
+		return fmt.Sprintf("%s IS NULL", QuoteIdent(t.Columns[i]))
+	}
+	return fmt.Sprintf("%s=%s", QuoteIdent(t.Columns[i]), t.formatValue(i, v))
+}
+
+// assignment 生成SET子句中的 `col`=value
+func (t *Table) assignment(i int, v interface{}) string {
+	return fmt.Sprintf("%s=%s", QuoteIdent(t.Columns[i]), t.formatValue(i, v))
+}
+
+// keyColumns 返回可以唯一定位一行的列：优先主键，其次非空唯一索引，都没有时返回nil
+func (t *Table) keyColumns() []string {
+	for _, keys := range [][]string{t.Pks, t.Uks} {
+		if len(keys) != 0 && t.columnIndexes(keys) != nil {
+			return keys
+		}
+	}
+	return nil
+}
+
+// columnIndexes 返回列名在 Columns 中的下标，有列不存在时返回nil
+func (t *Table) columnIndexes(columns []string) []int {
+	indexes := make([]int, 0, len(columns))
+	for _, col := range columns {
+		found := false
+		for i, c := range t.Columns {
+			if c == col {
+				indexes = append(indexes, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return indexes
+}
+
+// whereClause 生成定位一行数据的WHERE条件，WhereKeys 不为空时只使用这些列，否则使用整行
+func (t *Table) whereClause(row []interface{}) string {
+	var condition []string
+	if indexes := t.columnIndexes(t.WhereKeys); len(t.WhereKeys) != 0 && indexes != nil {
+		for _, i := range indexes {
+			condition = append(condition, t.condition(i, row[i]))
+		}
+	} else {
+		for i := range t.Columns {
+			condition = append(condition, t.condition(i, row[i]))
+		}
+	}
+	return strings.Join(condition, " AND ")
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// unquoteString 按MySQL解析字符串字面量的规则还原 QuoteString 的结果
+func unquoteString(t *testing.T, s string, noBackslashEscapes bool) string {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		t.Fatalf("not a quoted string: %s", s)
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			if i+1 >= len(s) || s[i+1] != '\'' {
+				t.Fatalf("unescaped quote in %s", s)
+			}
+			b.WriteByte('\'')
+			i++
+		case c == '\\' && !noBackslashEscapes:
+			if i+1 >= len(s) {
+				t.Fatalf("dangling backslash in %s", s)
+			}
+			i++
+			switch s[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 'Z':
+				b.WriteByte('\x1a')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func TestQuoteString(t *testing.T) {
+	values := []string{"", "hello", "it's", `a\b`, "line1\nline2\r\n", "x\x00y\x1az", `"quoted"`, `\'; DROP TABLE t; -- `, "中文"}
+	for _, noBackslash := range []bool{false, true} {
+		for _, v := range values {
+			quoted := QuoteString(v, noBackslash)
+			if got := unquoteString(t, quoted, noBackslash); got != v {
+				t.Errorf("noBackslashEscapes=%v: %q -> %s -> %q", noBackslash, v, quoted, got)
+			}
+		}
+	}
+	if got := QuoteString(`it's a\b`, true); got != `'it''s a\b'` {
+		t.Error(got)
+	}
+	if got := QuoteString("it's\n", false); got != `'it\'s\n'` {
+		t.Error(got)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	cases := map[string]string{
+		"order": "`order`",
+		"a`b":   "`a``b`",
+		"中文":    "`中文`",
+	}
+	for in, want := range cases {
+		if got := QuoteIdent(in); got != want {
+			t.Errorf("%s: got %s want %s", in, got, want)
+		}
+	}
+}
+
+func Test_generateSqlEscaping(t *testing.T) {
+	tab := &Table{Schema: "test", Table: "order", Columns: []string{"id", "desc"}, Pks: []string{"id"}}
+	row := []interface{}{1, "it's\\"}
+	if sql := generateInsertSql(tab, row); sql != "INSERT INTO `test`.`order`(`id`,`desc`) VALUES(1,'it\\'s\\\\');" {
+		t.Error(sql)
+	}
+	if sql := generateDeleteSql(tab, []interface{}{1, nil}); sql != "DELETE FROM `test`.`order` WHERE `id`=1 AND `desc` IS NULL LIMIT 1;" {
+		t.Error(sql)
+	}
+	tab.NoBackslashEscapes = true
+	if sql := generateUpdateSql(tab, row, []interface{}{1, nil}); sql != "UPDATE `test`.`order` SET `id`=1,`desc`=NULL WHERE `id`=1 AND `desc`='it''s\\' LIMIT 1;" {
+		t.Error(sql)
+	}
+}
+
+func Test_whereClause(t *testing.T) {
+	tab := &Table{Schema: "test", Table: "t", Columns: []string{"id", "code", "price"}, Uks: []string{"code"}}
+	row := []interface{}{1, "a", 1.5}
+	tab.WhereKeys = tab.keyColumns()
+	if sql := generateDeleteSql(tab, row); sql != "DELETE FROM `test`.`t` WHERE `code`='a' LIMIT 1;" {
+		t.Error(sql)
+	}
+	tab.Pks = []string{"id"}
+	tab.WhereKeys = tab.keyColumns()
+	if sql := generateUpdateSql(tab, row, []interface{}{2, "a", 2.5}); sql != "UPDATE `test`.`t` SET `id`=2,`code`='a',`price`=2.5 WHERE `id`=1 LIMIT 1;" {
+		t.Error(sql)
+	}
+	if sql := genSimpleUpdateSql(tab, row, []interface{}{1, "a", 2.5}); sql != "UPDATE `test`.`t` SET `id`=1,`price`=2.5 WHERE `id`=1 LIMIT 1;" {
+		t.Error(sql)
+	}
+	tab.Pks, tab.Uks = nil, nil
+	if tab.WhereKeys = tab.keyColumns(); tab.WhereKeys != nil {
+		t.Error(tab.WhereKeys)
+	}
+	if sql := generateDeleteSql(tab, row); sql != "DELETE FROM `test`.`t` WHERE `id`=1 AND `code`='a' AND `price`=1.5 LIMIT 1;" {
+		t.Error(sql)
+	}
+}
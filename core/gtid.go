@@ -0,0 +1,69 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+)
+
+// GtidOfEvent 返回GTID事件中的GTID，如 3e11fa47-71ca-11e1-9e33-c80aa9429562:23，
+// ANONYMOUS_GTID_EVENT 返回空字符串
+func GtidOfEvent(e *replication.BinlogEvent) (gtid string, ok bool) {
+	switch ev := e.Event.(type) {
+	case *replication.GTIDEvent:
+		if e.Header.EventType == replication.ANONYMOUS_GTID_EVENT {
+			return "", true
+		}
+		u, err := uuid.FromBytes(ev.SID)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("%s:%d", u.String(), ev.GNO), true
+	case *replication.MariadbGTIDEvent:
+		return ev.GTID.String(), true
+	}
+	return "", false
+}
+
+// CheckGtidFlavor 检查GTID过滤条件能否用于该GTID事件。-start-gtid/-stop-gtid/-include-gtids/-exclude-gtids
+// 只支持MySQL的GTID集合，MariaDB的GTID集合只记录每个domain执行到的位置，不能表示事务范围，遇到MariaDB的GTID事件时报错
+func CheckGtidFlavor(cfg *conf.Config, e *replication.BinlogEvent) error {
+	ev, ok := e.Event.(*replication.MariadbGTIDEvent)
+	if !ok {
+		return nil
+	}
+	if cfg.StartGtid != nil || cfg.StopGtid != nil || cfg.IncludeGtids != nil || cfg.ExcludeGtids != nil {
+		return fmt.Errorf("-start-gtid/-stop-gtid/-include-gtids/-exclude-gtids only support MySQL GTID sets, got MariaDB GTID %s", ev.GTID.String())
+	}
+	return nil
+}
+
+func gtidIn(set mysql.GTIDSet, gtid string) bool {
+	if set == nil || gtid == "" {
+		return false
+	}
+	g, err := mysql.ParseMysqlGTIDSet(gtid)
+	if err != nil {
+		return false
+	}
+	return set.Contain(g)
+}
+
+// SkipGtid 判断GTID对应的事务是否被 -start-gtid/-include-gtids/-exclude-gtids 过滤掉
+func SkipGtid(cfg *conf.Config, gtid string) bool {
+	if gtidIn(cfg.StartGtid, gtid) {
+		return true
+	}
+	if cfg.IncludeGtids != nil && !gtidIn(cfg.IncludeGtids, gtid) {
+		return true
+	}
+	return gtidIn(cfg.ExcludeGtids, gtid)
+}
+
+// IsStopGtid 判断解析完该事务后是否需要停止
+func IsStopGtid(cfg *conf.Config, gtid string) bool {
+	return gtidIn(cfg.StopGtid, gtid)
+}
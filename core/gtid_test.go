@@ -0,0 +1,53 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestSkipGtid(t *testing.T) {
+	parse := func(s string) mysql.GTIDSet {
+		set, err := mysql.ParseMysqlGTIDSet(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return set
+	}
+	const sid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	cfg := &conf.Config{
+		StartGtid:    parse(sid + ":1-5"),
+		IncludeGtids: parse(sid + ":1-100"),
+		ExcludeGtids: parse(sid + ":7:9-10"),
+		StopGtid:     parse(sid + ":20"),
+	}
+	cases := map[string]bool{sid + ":3": true, sid + ":6": false, sid + ":7": true, sid + ":10": true, sid + ":11": false, sid + ":101": true, "": true}
+	for gtid, skip := range cases {
+		if got := SkipGtid(cfg, gtid); got != skip {
+			t.Errorf("%s: got %v want %v", gtid, got, skip)
+		}
+	}
+	if !IsStopGtid(cfg, sid+":20") || IsStopGtid(cfg, sid+":19") {
+		t.Error("stop gtid")
+	}
+}
+
+func TestCheckGtidFlavor(t *testing.T) {
+	e := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.MARIADB_GTID_EVENT},
+		Event:  &replication.MariadbGTIDEvent{GTID: mysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: 100}},
+	}
+	if err := CheckGtidFlavor(&conf.Config{}, e); err != nil {
+		t.Fatal(err)
+	}
+	set, _ := mysql.ParseMysqlGTIDSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5")
+	if err := CheckGtidFlavor(&conf.Config{IncludeGtids: set}, e); err == nil {
+		t.Error("expect error for MariaDB GTID with -include-gtids")
+	}
+	mysqlEvent := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.GTID_EVENT}, Event: &replication.GTIDEvent{}}
+	if err := CheckGtidFlavor(&conf.Config{IncludeGtids: set}, mysqlEvent); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Job 为流水线中的一个binlog事件，行事件的行数据解码及SQL生成由工作goroutine预先完成
+type Job struct {
+	Event *replication.BinlogEvent
+
+	// rows 为未解码的行事件数据，pos 为行数据的起始位置
+	rows []byte
+	pos  int
+
+	rendered  bool
+	sql       string
+	changes   []*RowChange
+	err       error
+	decodeErr error
+	done      chan struct{}
+}
+
+// Sql 返回行事件生成的SQL，工作goroutine没有预先生成时在当前goroutine中生成
+func (j *Job) Sql(cfg *conf.Config) (string, error) {
+	if j.rendered && cfg.OutputFormat == "sql" {
+		return j.sql, j.err
+	}
+	return ConcatSqlFromRowsEvent(j.Event, cfg)
+}
+
+// RowChanges 返回行事件的行变更，工作goroutine没有预先生成时在当前goroutine中生成
+func (j *Job) RowChanges(cfg *conf.Config) ([]*RowChange, error) {
+	if j.rendered && cfg.OutputFormat != "sql" {
+		return j.changes, j.err
+	}
+	return RowChangesFromRowsEvent(j.Event, cfg)
+}
+
+// render 解码行数据，并按输出格式预先生成SQL或行变更。
+// 事件是否被过滤要按顺序处理时才能确定，这里不考虑过滤条件
+func (j *Job) render(cfg *conf.Config) {
+	if j.rows != nil {
+		if j.decodeErr = j.Event.Event.(*replication.RowsEvent).DecodeData(j.pos, j.rows); j.decodeErr != nil {
+			return
+		}
+		j.rows = nil
+	}
+	// -verify、-squash 不使用生成的SQL
+	if cfg.Verify || cfg.Squash {
+		return
+	}
+	if cfg.OutputFormat == "sql" {
+		j.sql, j.err = ConcatSqlFromRowsEvent(j.Event, cfg)
+	} else {
+		j.changes, j.err = RowChangesFromRowsEvent(j.Event, cfg)
+	}
+	j.rendered = true
+}
+
+type rowsData struct {
+	data []byte
+	pos  int
+}
+
+// Pipeline 由多个工作goroutine并发解码行事件并生成SQL，再按binlog顺序逐个交给 handle 处理。
+// 回滚时的倒序由 handle 输出到 FlashbackBuffer 完成，与单线程时一致。
+// DDL会改变之后行事件的表结构，提交DDL时等待之前的事件全部处理完，DDL处理完后才继续提交
+type Pipeline struct {
+	cfg    *conf.Config
+	handle func(*Job) error
+	jobs   chan *Job
+	order  chan *Job
+
+	// headers 为 DecodeRowsHeader 只解码了头部的行事件
+	mu      sync.Mutex
+	headers map[*replication.RowsEvent]rowsData
+	err     error
+
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+	merged  chan struct{}
+}
+
+// NewPipeline 启动 threads 个工作goroutine，handle 在同一个goroutine中按binlog顺序被调用
+func NewPipeline(threads int, cfg *conf.Config, handle func(*Job) error) *Pipeline {
+	if threads < 1 {
+		threads = 1
+	}
+	p := &Pipeline{
+		cfg:     cfg,
+		handle:  handle,
+		jobs:    make(chan *Job, threads*64),
+		order:   make(chan *Job, threads*64),
+		headers: make(map[*replication.RowsEvent]rowsData),
+		merged:  make(chan struct{}),
+	}
+	for i := 0; i < threads; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	go p.merge()
+	return p
+}
+
+// DecodeRowsHeader 用作 BinlogParser.SetRowsEventDecodeFunc 及 BinlogSyncerConfig.RowsEventDecodeFunc，
+// 只解码行事件的头部，行数据由工作goroutine解码
+func (p *Pipeline) DecodeRowsHeader(e *replication.RowsEvent, data []byte) error {
+	pos, err := e.DecodeHeader(data)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.headers[e] = rowsData{data: data, pos: pos}
+	p.mu.Unlock()
+	return nil
+}
+
+// Submit 按binlog顺序提交事件，可直接作为解析binlog的回调。之前的事件处理出错时返回该错误
+func (p *Pipeline) Submit(e *replication.BinlogEvent) error {
+	if err := p.Err(); err != nil {
+		return err
+	}
+	j := &Job{Event: e, done: make(chan struct{})}
+	p.pending.Add(1)
+	if re, ok := e.Event.(*replication.RowsEvent); ok {
+		p.mu.Lock()
+		if d, ok := p.headers[re]; ok {
+			j.rows, j.pos = d.data, d.pos
+			delete(p.headers, re)
+		}
+		p.mu.Unlock()
+		p.order <- j
+		p.jobs <- j
+		return nil
+	}
+	close(j.done)
+	p.order <- j
+	if qe, ok := e.Event.(*replication.QueryEvent); ok && !isTrxQuery(string(qe.Query)) {
+		return p.Wait()
+	}
+	return nil
+}
+
+// Wait 等待已提交的事件全部处理完，返回处理中的错误
+func (p *Pipeline) Wait() error {
+	p.pending.Wait()
+	return p.Err()
+}
+
+// Err 返回 handle 或解码行数据返回的第一个错误
+func (p *Pipeline) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close 等待已提交的事件处理完并停止所有goroutine，之后不能再调用 Submit
+func (p *Pipeline) Close() error {
+	close(p.jobs)
+	close(p.order)
+	p.workers.Wait()
+	<-p.merged
+	return p.Err()
+}
+
+func (p *Pipeline) work() {
+	defer p.workers.Done()
+	for j := range p.jobs {
+		j.render(p.cfg)
+		close(j.done)
+	}
+}
+
+// merge 按提交顺序等待事件处理完成并调用 handle，出错后之后的事件都不再处理
+func (p *Pipeline) merge() {
+	defer close(p.merged)
+	for j := range p.order {
+		<-j.done
+		if p.Err() == nil {
+			err := j.decodeErr
+			if err == nil {
+				err = p.handle(j)
+			}
+			if err != nil {
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+			}
+		}
+		p.pending.Done()
+	}
+}
+
+func isTrxQuery(query string) bool {
+	return query == "BEGIN" || query == "COMMIT"
+}
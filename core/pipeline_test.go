@@ -0,0 +1,188 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// pipelineEvents 生成 n 个行事件，每个事件 rows 行，每隔10个事件插入一个DDL
+func pipelineEvents(n, rows int) []*replication.BinlogEvent {
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 200, 200},
+		ColumnName:  [][]byte{[]byte("id"), []byte("name"), []byte("note")},
+		PrimaryKey:  []uint64{0},
+	}
+	var events []*replication.BinlogEvent
+	for i := 0; i < n; i++ {
+		if i%10 == 0 {
+			events = append(events, &replication.BinlogEvent{
+				Header: &replication.EventHeader{EventType: replication.QUERY_EVENT},
+				Event:  &replication.QueryEvent{Schema: []byte("test"), Query: []byte("CREATE TABLE x(id int)")},
+			})
+		}
+		re := &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 3}
+		for r := 0; r < rows; r++ {
+			id := int32(i*rows + r)
+			re.Rows = append(re.Rows, []interface{}{id, fmt.Sprintf("name-%d", id), "it's a \"note\"\n"})
+		}
+		events = append(events, &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2},
+			Event:  re,
+		})
+	}
+	return events
+}
+
+func TestPipeline(t *testing.T) {
+	cfg := &conf.Config{WhereMode: "auto", OutputFormat: "sql"}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	events := pipelineEvents(100, 3)
+	var want []string
+	for _, e := range events {
+		if _, ok := e.Event.(*replication.RowsEvent); !ok {
+			want = append(want, "query")
+			continue
+		}
+		sql, err := ConcatSqlFromRowsEvent(e, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, sql)
+	}
+
+	var got []string
+	p := NewPipeline(4, cfg, func(j *Job) error {
+		if _, ok := j.Event.Event.(*replication.RowsEvent); !ok {
+			got = append(got, "query")
+			return nil
+		}
+		sql, err := j.Sql(cfg)
+		got = append(got, sql)
+		return err
+	})
+	for _, e := range events {
+		if err := p.Submit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("pipeline output is not in binlog order")
+	}
+
+	// 出错后之后的事件不再处理
+	errStop := errors.New("stop")
+	handled := 0
+	p = NewPipeline(4, cfg, func(j *Job) error {
+		if handled++; handled == 50 {
+			return errStop
+		}
+		return nil
+	})
+	for _, e := range events {
+		if err := p.Submit(e); err != nil {
+			break
+		}
+	}
+	if err := p.Wait(); err != errStop {
+		t.Fatalf("got error %v, want %v", err, errStop)
+	}
+	if err := p.Close(); err != errStop || handled != 50 {
+		t.Fatalf("got error %v after %d events", err, handled)
+	}
+}
+
+// BenchmarkPipeline 对比单线程与多线程生成SQL的耗时，go test -bench Pipeline ./core。
+// threads-1 与 serial 的差为流水线本身的开销，-threads 1 时不使用流水线
+func BenchmarkPipeline(b *testing.B) {
+	cfg := &conf.Config{WhereMode: "auto", OutputFormat: "sql"}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	events := pipelineEvents(1000, 50)
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, e := range events {
+				if _, ok := e.Event.(*replication.RowsEvent); ok {
+					if _, err := ConcatSqlFromRowsEvent(e, cfg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		}
+	})
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads-%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := NewPipeline(threads, cfg, func(j *Job) error {
+					if _, ok := j.Event.Event.(*replication.RowsEvent); ok {
+						_, err := j.Sql(cfg)
+						return err
+					}
+					return nil
+				})
+				for _, e := range events {
+					if err := p.Submit(e); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := p.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPipelineLocalFile 解析 BINLOG2SQL_BENCH_FILE 指定的binlog文件(需要 binlog_row_metadata=FULL)，
+// 包含行数据解码，go test -bench PipelineLocalFile -benchtime 3x ./core
+func BenchmarkPipelineLocalFile(b *testing.B) {
+	file := os.Getenv("BINLOG2SQL_BENCH_FILE")
+	if file == "" {
+		b.Skip("BINLOG2SQL_BENCH_FILE is not set")
+	}
+	cfg := &conf.Config{WhereMode: "auto", OutputFormat: "sql"}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	handle := func(j *Job) error {
+		if _, ok := j.Event.Event.(*replication.RowsEvent); ok {
+			_, err := j.Sql(cfg)
+			return err
+		}
+		return nil
+	}
+	for _, threads := range []int{0, 1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads-%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				parser := replication.NewBinlogParser()
+				parser.SetUseDecimal(true)
+				if threads == 0 {
+					if err := parser.ParseFile(file, 4, func(e *replication.BinlogEvent) error {
+						return handle(&Job{Event: e})
+					}); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				p := NewPipeline(threads, cfg, handle)
+				parser.SetRowsEventDecodeFunc(p.DecodeRowsHeader)
+				if err := parser.ParseFile(file, 4, p.Submit); err != nil {
+					b.Fatal(err)
+				}
+				if err := p.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
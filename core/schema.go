@@ -0,0 +1,410 @@
+package core
+
+import (
+	"binlog2sql_go/utils"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	_ "github.com/pingcap/tidb/parser/test_driver"
+)
+
+// SchemaTracker 维护解析过程中各表的表结构。
+// 某张表第一次被用到时通过 load 从数据库加载，之后随binlog中的DDL同步变更，
+// 使旧的row event始终按当时的列定义解析
+type SchemaTracker struct {
+	mu     sync.Mutex
+	tables map[string]*Table
+	load   func(schema, table string) (*Table, error)
+	parser *parser.Parser
+}
+
+func NewSchemaTracker(load func(schema, table string) (*Table, error)) *SchemaTracker {
+	return &SchemaTracker{tables: make(map[string]*Table), load: load, parser: parser.New()}
+}
+
+func tableKey(schema, table string) string {
+	return schema + "." + table
+}
+
+// Get 返回表结构定义，返回值不能被修改
+func (s *SchemaTracker) Get(schema, table string) (*Table, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(schema, table)
+}
+
+func (s *SchemaTracker) get(schema, table string) (*Table, error) {
+	if t, ok := s.tables[tableKey(schema, table)]; ok {
+		return t, nil
+	}
+	if s.load == nil {
+		return nil, fmt.Errorf("no schema for table %s.%s", schema, table)
+	}
+	t, err := s.load(schema, table)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || len(t.Columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found", schema, table)
+	}
+	s.tables[tableKey(schema, table)] = t
+	return t, nil
+}
+
+// Set 直接设置表结构定义
+func (s *SchemaTracker) Set(t *Table) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[tableKey(t.Schema, t.Table)] = t
+}
+
+// ApplyDDL 解析语句并更新受影响的表结构，schema为语句执行时的默认库。
+// 非DDL语句或解析失败的语句会被忽略，changed 表示是否有表结构发生变化
+func (s *SchemaTracker) ApplyDDL(schema, query string, timestamp uint32) (changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stmts, _, err := s.parser.Parse(query, "", "")
+	if err != nil {
+		return false, err
+	}
+	for _, stmt := range stmts {
+		switch st := stmt.(type) {
+		case *ast.CreateTableStmt:
+			s.applyCreateTable(schema, st)
+		case *ast.AlterTableStmt:
+			s.applyAlterTable(schema, st, timestamp)
+		case *ast.RenameTableStmt:
+			for _, t2t := range st.TableToTables {
+				s.rename(schema, t2t.OldTable, t2t.NewTable)
+			}
+		case *ast.DropTableStmt:
+			for _, tn := range st.Tables {
+				delete(s.tables, tableKey(schemaOf(schema, tn), tn.Name.O))
+			}
+		case *ast.DropDatabaseStmt:
+			for key, t := range s.tables {
+				if t.Schema == st.Name {
+					delete(s.tables, key)
+				}
+			}
+		default:
+			continue
+		}
+		changed = true
+	}
+	return
+}
+
+func schemaOf(defaultSchema string, tn *ast.TableName) string {
+	if tn.Schema.O != "" {
+		return tn.Schema.O
+	}
+	return defaultSchema
+}
+
+func (s *SchemaTracker) applyCreateTable(schema string, st *ast.CreateTableStmt) {
+	key := tableKey(schemaOf(schema, st.Table), st.Table.Name.O)
+	if _, ok := s.tables[key]; ok && st.IfNotExists {
+		return
+	}
+	switch {
+	case st.ReferTable != nil:
+		// CREATE TABLE ... LIKE
+		ref, err := s.get(schemaOf(schema, st.ReferTable), st.ReferTable.Name.O)
+		if err != nil {
+			delete(s.tables, key)
+			return
+		}
+		t := ref.clone()
+		t.Schema, t.Table, t.loadedAt = schemaOf(schema, st.Table), st.Table.Name.O, 0
+		s.tables[key] = t
+	case st.Select != nil:
+		// CREATE TABLE ... SELECT 的列无法从语句中得到，等用到时再加载
+		delete(s.tables, key)
+	default:
+		s.tables[key] = tableFromCreate(schema, st)
+	}
+}
+
+func (s *SchemaTracker) rename(schema string, from, to *ast.TableName) {
+	oldKey := tableKey(schemaOf(schema, from), from.Name.O)
+	newKey := tableKey(schemaOf(schema, to), to.Name.O)
+	t, ok := s.tables[oldKey]
+	delete(s.tables, oldKey)
+	delete(s.tables, newKey)
+	if ok {
+		t = t.clone()
+		t.Schema, t.Table = schemaOf(schema, to), to.Name.O
+		s.tables[newKey] = t
+	}
+}
+
+// applyAlterTable 应用 ALTER TABLE，timestamp 为语句在binlog中的时间。
+// 从当前库加载的表结构已包含加载之前执行的DDL，不再重复应用
+func (s *SchemaTracker) applyAlterTable(schema string, st *ast.AlterTableStmt, timestamp uint32) {
+	name := st.Table.Name.O
+	schema = schemaOf(schema, st.Table)
+	old, err := s.get(schema, name)
+	if err != nil {
+		// 当前库中已不存在该表，无法跟踪
+		return
+	}
+	if old.loadedAt != 0 && timestamp <= old.loadedAt {
+		return
+	}
+	t := old.clone()
+	for _, spec := range st.Specs {
+		switch spec.Tp {
+		case ast.AlterTableAddColumns:
+			for _, col := range spec.NewColumns {
+				if t.indexOf(col.Name.Name.O) >= 0 {
+					continue
+				}
+				t.insertColumn(t.position(spec.Position, len(t.Columns)), col)
+			}
+		case ast.AlterTableDropColumn:
+			t.dropColumn(spec.OldColumnName.Name.O)
+		case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+			if len(spec.NewColumns) == 0 {
+				continue
+			}
+			oldName := spec.NewColumns[0].Name.Name.O
+			if spec.Tp == ast.AlterTableChangeColumn {
+				oldName = spec.OldColumnName.Name.O
+			}
+			i := t.indexOf(oldName)
+			if i < 0 {
+				continue
+			}
+			pos := i
+			if spec.Position != nil && spec.Position.Tp != ast.ColumnPositionNone {
+				pos = -1
+			}
+			pks, uks := t.Pks, t.Uks
+			t.dropColumn(oldName)
+			t.Pks, t.Uks = pks, uks
+			if pos < 0 {
+				pos = t.position(spec.Position, len(t.Columns))
+			}
+			t.insertColumn(pos, spec.NewColumns[0])
+			t.renameKeyColumn(oldName, spec.NewColumns[0].Name.Name.O)
+		case ast.AlterTableRenameColumn:
+			if i := t.indexOf(spec.OldColumnName.Name.O); i >= 0 {
+				t.Columns[i] = spec.NewColumnName.Name.O
+				t.renameKeyColumn(spec.OldColumnName.Name.O, spec.NewColumnName.Name.O)
+			}
+		case ast.AlterTableAddConstraint:
+			if spec.Constraint != nil && spec.Constraint.Tp == ast.ConstraintPrimaryKey {
+				t.Pks = indexColumns(spec.Constraint)
+			}
+		case ast.AlterTableDropPrimaryKey:
+			t.Pks = nil
+		case ast.AlterTableDropIndex:
+			// 只记录了唯一索引的列，无法确认被删除的是否就是它，保守起见不再使用
+			t.Uks = nil
+		case ast.AlterTableRenameTable:
+			delete(s.tables, tableKey(schema, name))
+			schema, name = schemaOf(schema, spec.NewTable), spec.NewTable.Name.O
+			t.Schema, t.Table = schema, name
+		}
+	}
+	s.tables[tableKey(schema, name)] = t
+}
+
+// tableFromCreate 根据 CREATE TABLE 语句生成表结构定义
+func tableFromCreate(schema string, st *ast.CreateTableStmt) *Table {
+	t := &Table{Schema: schemaOf(schema, st.Table), Table: st.Table.Name.O}
+	notNull := make(map[string]bool)
+	for _, col := range st.Cols {
+		name := col.Name.Name.O
+		t.insertColumn(len(t.Columns), col)
+		for _, opt := range col.Options {
+			switch opt.Tp {
+			case ast.ColumnOptionNotNull, ast.ColumnOptionPrimaryKey:
+				notNull[strings.ToLower(name)] = true
+			case ast.ColumnOptionUniqKey:
+				if t.Uks == nil {
+					t.Uks = []string{name}
+				}
+			}
+		}
+	}
+	for _, c := range st.Constraints {
+		switch c.Tp {
+		case ast.ConstraintPrimaryKey:
+			t.Pks = indexColumns(c)
+			for _, col := range t.Pks {
+				notNull[strings.ToLower(col)] = true
+			}
+		}
+	}
+	var uks []string
+	if len(t.Uks) != 0 && notNull[strings.ToLower(t.Uks[0])] {
+		uks = t.Uks
+	}
+	for _, c := range st.Constraints {
+		if uks != nil {
+			break
+		}
+		switch c.Tp {
+		case ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+			cols := indexColumns(c)
+			allNotNull := cols != nil
+			for _, col := range cols {
+				allNotNull = allNotNull && notNull[strings.ToLower(col)]
+			}
+			if allNotNull {
+				uks = cols
+			}
+		}
+	}
+	t.Uks = uks
+	return t
+}
+
+// tableFromTableMap 根据 binlog_row_metadata=FULL 时TableMapEvent中的列名、主键生成表结构定义，
+// 列类型、符号、ENUM/SET取值等在渲染时直接从 Meta 中获取
+func tableFromTableMap(tm *replication.TableMapEvent) *Table {
+	t := &Table{Schema: string(tm.Schema), Table: string(tm.Table), Columns: tm.ColumnNameString(), Meta: tm, autoIncrementUnknown: true}
+	for _, i := range tm.PrimaryKey {
+		if int(i) < len(t.Columns) {
+			t.Pks = append(t.Pks, t.Columns[i])
+		}
+	}
+	return t
+}
+
+// setMeta 设置表的TableMapEvent并解析其中的列属性，用于缓存的表结构定义，之后只读
+func (t *Table) setMeta(tm *replication.TableMapEvent) {
+	t.Meta = tm
+	t.unsigned, t.collations = tm.UnsignedMap(), tm.CollationMap()
+	t.enums, t.sets = tm.EnumStrValueMap(), tm.SetStrValueMap()
+	// 没有元数据时为nil，置为空避免每次使用时重新解析
+	if t.unsigned == nil {
+		t.unsigned = map[int]bool{}
+	}
+	if t.collations == nil {
+		t.collations = map[int]uint64{}
+	}
+	if t.enums == nil {
+		t.enums = map[int][]string{}
+	}
+	if t.sets == nil {
+		t.sets = map[int][]string{}
+	}
+}
+
+// mergeKeys 从跟踪的表结构中补充元数据中没有的唯一索引和自增列，列已不存在(表结构已变化)时忽略
+func (t *Table) mergeKeys(tracked *Table) {
+	t.autoIncrementUnknown = false
+	if len(t.Uks) == 0 && len(tracked.Uks) != 0 && t.columnIndexes(tracked.Uks) != nil {
+		t.Uks = tracked.Uks
+	}
+	for _, col := range tracked.AutoIncrement {
+		if i := t.indexOf(col); i >= 0 && !utils.Contains(t.AutoIncrement, t.Columns[i]) {
+			t.AutoIncrement = append(t.AutoIncrement, t.Columns[i])
+		}
+	}
+}
+
+// indexColumns 返回索引中的列，包含表达式的索引返回nil
+func indexColumns(c *ast.Constraint) (columns []string) {
+	for _, key := range c.Keys {
+		if key.Column == nil {
+			return nil
+		}
+		columns = append(columns, key.Column.Name.O)
+	}
+	return
+}
+
+func (t *Table) clone() *Table {
+	c := *t
+	c.Columns = append([]string(nil), t.Columns...)
+	c.ColumnTypes = append([]string(nil), t.ColumnTypes...)
+	c.Pks = append([]string(nil), t.Pks...)
+	c.Uks = append([]string(nil), t.Uks...)
+	c.AutoIncrement = append([]string(nil), t.AutoIncrement...)
+	return &c
+}
+
+// indexOf 返回列的下标，列名不区分大小写，不存在时返回-1
+func (t *Table) indexOf(column string) int {
+	for i, col := range t.Columns {
+		if strings.EqualFold(col, column) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Table) position(pos *ast.ColumnPosition, def int) int {
+	if pos == nil {
+		return def
+	}
+	switch pos.Tp {
+	case ast.ColumnPositionFirst:
+		return 0
+	case ast.ColumnPositionAfter:
+		if i := t.indexOf(pos.RelativeColumn.Name.O); i >= 0 {
+			return i + 1
+		}
+	}
+	return def
+}
+
+func (t *Table) insertColumn(i int, col *ast.ColumnDef) {
+	name := col.Name.Name.O
+	for len(t.ColumnTypes) < len(t.Columns) {
+		t.ColumnTypes = append(t.ColumnTypes, "")
+	}
+	t.Columns = append(t.Columns[:i], append([]string{name}, t.Columns[i:]...)...)
+	t.ColumnTypes = append(t.ColumnTypes[:i], append([]string{col.Tp.InfoSchemaStr()}, t.ColumnTypes[i:]...)...)
+	for _, opt := range col.Options {
+		switch opt.Tp {
+		case ast.ColumnOptionPrimaryKey:
+			t.Pks = []string{name}
+		case ast.ColumnOptionAutoIncrement:
+			t.AutoIncrement = append(t.AutoIncrement, name)
+		}
+	}
+}
+
+func (t *Table) dropColumn(column string) {
+	i := t.indexOf(column)
+	if i < 0 {
+		return
+	}
+	t.Columns = append(t.Columns[:i], t.Columns[i+1:]...)
+	if i < len(t.ColumnTypes) {
+		t.ColumnTypes = append(t.ColumnTypes[:i], t.ColumnTypes[i+1:]...)
+	}
+	t.Pks = removeColumn(t.Pks, column)
+	t.AutoIncrement = removeColumn(t.AutoIncrement, column)
+	if len(removeColumn(t.Uks, column)) != len(t.Uks) {
+		t.Uks = nil
+	}
+}
+
+func (t *Table) renameKeyColumn(from, to string) {
+	for _, keys := range [][]string{t.Pks, t.Uks, t.AutoIncrement} {
+		for i, col := range keys {
+			if strings.EqualFold(col, from) {
+				keys[i] = to
+			}
+		}
+	}
+}
+
+func removeColumn(columns []string, column string) (res []string) {
+	for _, col := range columns {
+		if !strings.EqualFold(col, column) {
+			res = append(res, col)
+		}
+	}
+	return
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestSchemaTracker(t *testing.T) {
+	loaded := 0
+	tracker := NewSchemaTracker(func(schema, table string) (*Table, error) {
+		loaded++
+		return &Table{Schema: schema, Table: table, Columns: []string{"id", "a", "b"},
+			ColumnTypes: []string{"int(11)", "varchar(10)", "int(11)"}, Pks: []string{"id"}}, nil
+	})
+	ddls := []struct {
+		query   string
+		columns []string
+		types   []string
+	}{
+		{"ALTER TABLE t ADD COLUMN c varchar(20) AFTER id", []string{"id", "c", "a", "b"}, []string{"int(11)", "varchar(20)", "varchar(10)", "int(11)"}},
+		{"alter table test.t drop column a, add d bigint unsigned first", []string{"d", "id", "c", "b"}, []string{"bigint(20) unsigned", "int(11)", "varchar(20)", "int(11)"}},
+		{"ALTER TABLE t MODIFY b decimal(10,2) NOT NULL", []string{"d", "id", "c", "b"}, []string{"bigint(20) unsigned", "int(11)", "varchar(20)", "decimal(10,2)"}},
+		{"ALTER TABLE t CHANGE id uid bigint(20) AFTER b", []string{"d", "c", "b", "uid"}, []string{"bigint(20) unsigned", "varchar(20)", "decimal(10,2)", "bigint(20)"}},
+		{"ALTER TABLE t RENAME COLUMN c TO `order`", []string{"d", "order", "b", "uid"}, []string{"bigint(20) unsigned", "varchar(20)", "decimal(10,2)", "bigint(20)"}},
+	}
+	for _, ddl := range ddls {
+		if changed, err := tracker.ApplyDDL("test", ddl.query, 0); err != nil || !changed {
+			t.Fatalf("%s: changed=%v err=%v", ddl.query, changed, err)
+		}
+		tab, err := tracker.Get("test", "t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tab.Columns, ddl.columns) || !reflect.DeepEqual(tab.ColumnTypes, ddl.types) {
+			t.Errorf("%s: got %v %v", ddl.query, tab.Columns, tab.ColumnTypes)
+		}
+	}
+	if tab, _ := tracker.Get("test", "t"); !reflect.DeepEqual(tab.Pks, []string{"uid"}) {
+		t.Errorf("pks: %v", tab.Pks)
+	}
+	if _, err := tracker.ApplyDDL("test", "RENAME TABLE t TO t2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if tab, _ := tracker.Get("test", "t2"); tab.Table != "t2" || len(tab.Columns) != 4 {
+		t.Errorf("rename: %+v", tab)
+	}
+	if _, err := tracker.ApplyDDL("test", "DROP TABLE t2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tracker.tables["test.t2"]; ok {
+		t.Error("t2 not dropped")
+	}
+	if loaded != 1 {
+		t.Errorf("expect loaded once, got %d", loaded)
+	}
+	if changed, _ := tracker.ApplyDDL("test", "INSERT INTO t VALUES(1)", 0); changed {
+		t.Error("dml should not change schema")
+	}
+}
+
+func TestSchemaTrackerCreateTable(t *testing.T) {
+	tracker := NewSchemaTracker(nil)
+	_, err := tracker.ApplyDDL("test", "CREATE TABLE `u` (`id` int unsigned NOT NULL AUTO_INCREMENT, `code` varbinary(16) NOT NULL, `state` enum('a','b') DEFAULT NULL, PRIMARY KEY (`id`), UNIQUE KEY `uk` (`code`))", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tab, err := tracker.Get("test", "u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Table{Schema: "test", Table: "u", Columns: []string{"id", "code", "state"}, ColumnTypes: []string{"int(11) unsigned", "varbinary(16)", "enum('a','b')"},
+		Pks: []string{"id"}, Uks: []string{"code"}, AutoIncrement: []string{"id"}}
+	if !reflect.DeepEqual(tab, want) {
+		t.Errorf("got %+v", tab)
+	}
+	if _, err := tracker.Get("test", "missing"); err == nil {
+		t.Error("expect error for unknown table")
+	}
+}
+
+func Test_tableDefinitionFromTableMap(t *testing.T) {
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0, 40, 0},
+		ColumnName:  [][]byte{[]byte("id"), []byte("name"), []byte("order")},
+		PrimaryKey:  []uint64{0},
+	}
+	def, err := tableDefinition(&replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 3}, &conf.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(def.Columns, []string{"id", "name", "order"}) || !reflect.DeepEqual(def.Pks, []string{"id"}) {
+		t.Errorf("got %+v", def)
+	}
+
+	// 没有主键时从跟踪的表结构中补充唯一索引，-noPK 时补充自增列
+	saved := tracker
+	defer func() { tracker = saved; cachedTables.Reset() }()
+	tracker = NewSchemaTracker(nil)
+	if _, err = tracker.ApplyDDL("test", "CREATE TABLE t (id int NOT NULL AUTO_INCREMENT, name varchar(10) NOT NULL, `order` int, UNIQUE KEY uk (name), KEY (id))", 0); err != nil {
+		t.Fatal(err)
+	}
+	noPk := &replication.TableMapEvent{Schema: tm.Schema, Table: tm.Table, ColumnCount: 3, ColumnType: tm.ColumnType, ColumnMeta: tm.ColumnMeta, ColumnName: tm.ColumnName}
+	if def, err = tableDefinition(&replication.RowsEvent{Table: noPk, TableID: 1, ColumnCount: 3}, &conf.Config{NoPk: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(def.Uks, []string{"name"}) || !reflect.DeepEqual(def.AutoIncrement, []string{"id"}) {
+		t.Errorf("got %+v", def)
+	}
+	// 跟踪的表结构中的列已不存在时不补充
+	renamed := &replication.TableMapEvent{Schema: tm.Schema, Table: tm.Table, ColumnCount: 3, ColumnType: tm.ColumnType, ColumnMeta: tm.ColumnMeta,
+		ColumnName: [][]byte{[]byte("id"), []byte("title"), []byte("order")}}
+	if def, err = tableDefinition(&replication.RowsEvent{Table: renamed, TableID: 1, ColumnCount: 3}, &conf.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if def.Uks != nil {
+		t.Errorf("got %+v", def)
+	}
+}
+
+func TestSchemaMismatch(t *testing.T) {
+	saved := tracker
+	defer func() { tracker = saved; cachedTables.Reset() }()
+	// 跟踪的表结构是解析范围之后删除了一列的表结构
+	tracker = NewSchemaTracker(func(schema, table string) (*Table, error) {
+		return &Table{Schema: schema, Table: table, Columns: []string{"id", "a"}, ColumnTypes: []string{"int(11)", "int(11)"}, Pks: []string{"id"}}, nil
+	})
+	cachedTables.Reset()
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0, 0, 0},
+	}
+	e := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2},
+		Event:  &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 3, Rows: [][]interface{}{{int32(1), int32(2), int32(3)}}},
+	}
+	cfg := &conf.Config{WhereMode: "auto", OutputFormat: "sql"}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	_, err := ConcatSqlFromRowsEvent(e, cfg)
+	var mismatch *SchemaMismatchError
+	if !errors.As(err, &mismatch) || mismatch.BinlogColumns != 3 || mismatch.TrackedColumns != 2 {
+		t.Fatalf("got error %v, want SchemaMismatchError", err)
+	}
+}
+
+func TestSchemaMismatchColumnType(t *testing.T) {
+	saved := tracker
+	defer func() { tracker = saved; cachedTables.Reset() }()
+	// 列数相同，但第二列在解析范围之后由 int 改为 varchar
+	tracker = NewSchemaTracker(func(schema, table string) (*Table, error) {
+		return &Table{Schema: schema, Table: table, Columns: []string{"id", "a"}, ColumnTypes: []string{"int(11)", "varchar(10)"}, Pks: []string{"id"}}, nil
+	})
+	cachedTables.Reset()
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONG},
+		ColumnMeta:  []uint16{0, 0},
+	}
+	re := &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 2, Rows: [][]interface{}{{int32(1), int32(2)}}}
+	_, err := eventTable(re, &conf.Config{})
+	var mismatch *SchemaMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Column != "a" || mismatch.BinlogType != mysql.MYSQL_TYPE_LONG {
+		t.Fatalf("got error %v, want SchemaMismatchError on column a", err)
+	}
+
+	tm.ColumnType[1] = mysql.MYSQL_TYPE_VARCHAR
+	tm.ColumnMeta[1] = 40
+	if _, err = eventTable(re, &conf.Config{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_columnTypeMatches(t *testing.T) {
+	tests := []struct {
+		columnType string
+		tp         byte
+		want       bool
+	}{
+		{"int(11) unsigned", mysql.MYSQL_TYPE_LONG, true},
+		{"int", mysql.MYSQL_TYPE_LONGLONG, false},
+		{"datetime(3)", mysql.MYSQL_TYPE_DATETIME2, true},
+		{"decimal(10,2)", mysql.MYSQL_TYPE_NEWDECIMAL, true},
+		{"json", mysql.MYSQL_TYPE_BLOB, true},
+		{"enum('a','b')", mysql.MYSQL_TYPE_ENUM, true},
+		{"varchar(10)", mysql.MYSQL_TYPE_BLOB, false},
+		{"uuid", mysql.MYSQL_TYPE_STRING, true},
+	}
+	for _, tt := range tests {
+		if got := columnTypeMatches(tt.columnType, tt.tp); got != tt.want {
+			t.Errorf("columnTypeMatches(%q, %d) = %v, want %v", tt.columnType, tt.tp, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaTrackerLoadedAt(t *testing.T) {
+	tracker := NewSchemaTracker(func(schema, table string) (*Table, error) {
+		return &Table{Schema: schema, Table: table, Columns: []string{"id", "a"},
+			ColumnTypes: []string{"int(11)", "int(11)"}, Pks: []string{"id"}, loadedAt: 100}, nil
+	})
+	// 加载时表结构已包含该DDL，不重复应用
+	if _, err := tracker.ApplyDDL("test", "ALTER TABLE t ADD COLUMN b int", 100); err != nil {
+		t.Fatal(err)
+	}
+	if tab, _ := tracker.Get("test", "t"); len(tab.Columns) != 2 {
+		t.Errorf("ddl before load applied: %v", tab.Columns)
+	}
+	if _, err := tracker.ApplyDDL("test", "ALTER TABLE t ADD COLUMN b int", 101); err != nil {
+		t.Fatal(err)
+	}
+	if tab, _ := tracker.Get("test", "t"); !reflect.DeepEqual(tab.Columns, []string{"id", "a", "b"}) {
+		t.Errorf("ddl after load not applied: %v", tab.Columns)
+	}
+}
+
+func Test_tableDefinitionCache(t *testing.T) {
+	saved := tracker
+	defer func() { tracker = saved; cachedTables.Reset() }()
+	loads := 0
+	tracker = NewSchemaTracker(func(schema, table string) (*Table, error) {
+		loads++
+		return nil, errors.New("no connection")
+	})
+	cachedTables.Reset()
+	tableMap := func() *replication.TableMapEvent {
+		return &replication.TableMapEvent{
+			Schema:                []byte("test"),
+			Table:                 []byte("t"),
+			ColumnCount:           2,
+			ColumnType:            []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_STRING},
+			ColumnMeta:            []uint16{0, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1},
+			ColumnName:            [][]byte{[]byte("id"), []byte("e")},
+			EnumSetDefaultCharset: []uint64{255},
+			EnumStrValue:          [][][]byte{{[]byte("a"), []byte("b")}},
+		}
+	}
+	cfg := &conf.Config{}
+	tm := tableMap()
+	first, err := tableDefinition(&replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 2}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一个TableMapEvent的RowsEvent共用表结构定义，跟踪的表结构加载失败也只查询一次
+	for i := 0; i < 3; i++ {
+		if def, _ := tableDefinition(&replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 2}, cfg); def != first {
+			t.Fatal("definition not cached")
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expect tracker loaded once, got %d", loads)
+	}
+	if !reflect.DeepEqual(first.enums[1], []string{"a", "b"}) {
+		t.Errorf("enums: %v", first.enums)
+	}
+	// 新的TableMapEvent重新生成
+	if def, _ := tableDefinition(&replication.RowsEvent{Table: tableMap(), TableID: 1, ColumnCount: 2}, cfg); def == first {
+		t.Error("definition not rebuilt for a new TableMapEvent")
+	}
+}
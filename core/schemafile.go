@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+)
+
+// fileSchemas 为从 -schema-file 加载的表结构，key为 schema.table，
+// 未指定库名的表（mysqldump未使用--databases导出）key为 .table
+var fileSchemas = make(map[string]*Table)
+
+// LoadSchemaFile 加载离线的表结构文件，支持 mysqldump --no-data 导出的SQL文件，
+// 以及由 Table 组成的JSON数组：[{"schema":"test","table":"t","columns":["id","a"],"pks":["id"]}]
+func LoadSchemaFile(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var tables []*Table
+	if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '[' {
+		if err = json.Unmarshal(trimmed, &tables); err != nil {
+			return fmt.Errorf("parse schema file %s: %v", name, err)
+		}
+	} else if tables, err = parseSchemaSql(string(data)); err != nil {
+		return fmt.Errorf("parse schema file %s: %v", name, err)
+	}
+	for _, t := range tables {
+		if t.Table == "" || len(t.Columns) == 0 {
+			return fmt.Errorf("parse schema file %s: table %q without columns", name, t.Table)
+		}
+		fileSchemas[tableKey(t.Schema, t.Table)] = t
+	}
+	return nil
+}
+
+// loadTableFromFile 从 -schema-file 中查找表结构，找不到时返回nil
+func loadTableFromFile(schema, table string) *Table {
+	if t, ok := fileSchemas[tableKey(schema, table)]; ok {
+		return t
+	}
+	if t, ok := fileSchemas[tableKey("", table)]; ok {
+		t = t.clone()
+		t.Schema = schema
+		return t
+	}
+	return nil
+}
+
+// parseSchemaSql 解析SQL文件中的 CREATE TABLE 语句，USE 语句用于确定后续表所属的库，其他语句忽略
+func parseSchemaSql(data string) (tables []*Table, err error) {
+	p := parser.New()
+	var schema string
+	for _, stmt := range splitSqlStatements(data) {
+		nodes, _, err := p.Parse(stmt, "", "")
+		if err != nil {
+			if isCreateTable(stmt) {
+				return nil, err
+			}
+			continue
+		}
+		for _, node := range nodes {
+			switch st := node.(type) {
+			case *ast.UseStmt:
+				schema = st.DBName
+			case *ast.CreateTableStmt:
+				if st.ReferTable == nil && st.Select == nil {
+					tables = append(tables, tableFromCreate(schema, st))
+				}
+			}
+		}
+	}
+	return
+}
+
+func isCreateTable(stmt string) bool {
+	fields := strings.Fields(strings.ToUpper(stmt))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "CREATE" && (fields[i+1] == "TABLE" || fields[i+1] == "TEMPORARY") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSqlStatements 按分号拆分SQL文件，忽略引号和注释中的分号，支持mysql客户端的 DELIMITER 命令
+func splitSqlStatements(data string) (stmts []string) {
+	delimiter := ";"
+	var cur strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+	}
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case (i == 0 || data[i-1] == '\n') && strings.TrimSpace(cur.String()) == "" && hasPrefixFold(data[i:], "DELIMITER "):
+			end := strings.IndexByte(data[i:], '\n')
+			if end < 0 {
+				end = len(data) - i
+			}
+			if d := strings.TrimSpace(data[i+len("DELIMITER ") : i+end]); d != "" {
+				delimiter = d
+			}
+			cur.Reset()
+			i += end
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(data) {
+				if data[j] == '\\' && c != '`' {
+					j += 2
+					continue
+				}
+				if data[j] == c {
+					if j+1 < len(data) && data[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j >= len(data) {
+				j = len(data) - 1
+			}
+			cur.WriteString(data[i : j+1])
+			i = j + 1
+		case c == '#' || (c == '-' && strings.HasPrefix(data[i:], "-- ")):
+			end := strings.IndexByte(data[i:], '\n')
+			if end < 0 {
+				end = len(data) - i
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(data[i:], "/*") && !strings.HasPrefix(data[i:], "/*!"):
+			end := strings.Index(data[i+2:], "*/")
+			if end < 0 {
+				i = len(data)
+			} else {
+				i += end + 4
+			}
+		case strings.HasPrefix(data[i:], delimiter):
+			flush()
+			i += len(delimiter)
+		default:
+			cur.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
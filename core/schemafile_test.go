@@ -0,0 +1,76 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const mysqldumpNoData = `-- MySQL dump 10.13  Distrib 8.0.32, for Linux (x86_64)
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8mb4 */;
+
+CREATE DATABASE /*!32312 IF NOT EXISTS*/ ` + "`shop`" + ` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;
+USE ` + "`shop`" + `;
+
+--
+-- Table structure for table ` + "`order`" + `
+--
+
+DROP TABLE IF EXISTS ` + "`order`" + `;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+CREATE TABLE ` + "`order`" + ` (
+  ` + "`id`" + ` bigint unsigned NOT NULL AUTO_INCREMENT,
+  ` + "`note`" + ` varchar(64) DEFAULT 'a;b',
+  ` + "`sn`" + ` char(16) NOT NULL COMMENT 'it''s; unique',
+  PRIMARY KEY (` + "`id`" + `),
+  UNIQUE KEY ` + "`uk_sn`" + ` (` + "`sn`" + `)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+DELIMITER ;;
+/*!50003 CREATE*/ /*!50003 TRIGGER ` + "`trg`" + ` BEFORE INSERT ON ` + "`order`" + ` FOR EACH ROW BEGIN SET NEW.note = 'x'; END */;;
+DELIMITER ;
+`
+
+func TestLoadSchemaFile(t *testing.T) {
+	dir := t.TempDir()
+	sqlFile := filepath.Join(dir, "schema.sql")
+	jsonFile := filepath.Join(dir, "schema.json")
+	if err := os.WriteFile(sqlFile, []byte(mysqldumpNoData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jsonFile, []byte(`[{"table":"log","columns":["id","msg"],"column_types":["int(11)","text"]}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { fileSchemas = make(map[string]*Table) }()
+	for _, f := range []string{sqlFile, jsonFile} {
+		if err := LoadSchemaFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tab := loadTableFromFile("shop", "order")
+	if tab == nil {
+		t.Fatal("shop.order not loaded")
+	}
+	if !reflect.DeepEqual(tab.Columns, []string{"id", "note", "sn"}) || !reflect.DeepEqual(tab.Pks, []string{"id"}) ||
+		!reflect.DeepEqual(tab.Uks, []string{"sn"}) || !reflect.DeepEqual(tab.AutoIncrement, []string{"id"}) {
+		t.Errorf("got %+v", tab)
+	}
+	// 未指定库名的表对所有库生效
+	if tab = loadTableFromFile("any", "log"); tab == nil || tab.Schema != "any" || len(tab.Columns) != 2 {
+		t.Errorf("got %+v", tab)
+	}
+	if tab = loadTableFromFile("shop", "missing"); tab != nil {
+		t.Errorf("got %+v", tab)
+	}
+}
+
+func Test_splitSqlStatements(t *testing.T) {
+	stmts := splitSqlStatements("select 'a;b'; -- c;d\nselect `x;y` /* ; */;\nDELIMITER $$\nselect 1; select 2$$\n")
+	want := []string{"select 'a;b'", "select `x;y`", "select 1; select 2"}
+	if !reflect.DeepEqual(stmts, want) {
+		t.Errorf("got %q", stmts)
+	}
+}
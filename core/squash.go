@@ -0,0 +1,486 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"binlog2sql_go/utils"
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Squasher 为 -squash 模式下按 表+主键 合并的净变更。行数据在记录时即转换为SQL字面量，
+// 内存中超过 limit 个键后按键排序落盘，输出时归并所有落盘的文件。输出按表名、主键值排序
+type Squasher struct {
+	limit  int
+	tables []*squashTable
+	// tableIdx 为 表名+列 到 tables 下标的映射，表结构变化前后的行不会合并
+	tableIdx map[string]int
+	rows     map[string]*netChange
+	files    []string
+}
+
+// squashTable 为表结构快照
+type squashTable struct {
+	name    string
+	columns []string
+	keys    []int
+	// omit 为 -noPK 时INSERT中省略的 AUTO_INCREMENT 列
+	omit []bool
+}
+
+// netChange 为同一行在解析范围内的净变更：before 为第一次修改前的数据，after 为最后一次修改后的数据，
+// hasBefore/hasAfter 为false表示范围开始时不存在/范围结束时已删除
+type netChange struct {
+	key       string
+	table     int
+	hasBefore bool
+	hasAfter  bool
+	before    []string
+	after     []string
+	count     int
+	first     string
+	last      string
+}
+
+func NewSquasher(limit int) *Squasher {
+	if limit <= 0 {
+		limit = 100000
+	}
+	return &Squasher{limit: limit, tableIdx: make(map[string]int), rows: make(map[string]*netChange)}
+}
+
+// AddRowsEvent 合并RowsEvent中的行，表没有主键时返回false，由调用方按普通方式输出
+func (s *Squasher) AddRowsEvent(e *replication.BinlogEvent, cfg *conf.Config, file string) (bool, error) {
+	rowsEvent, ok := e.Event.(*replication.RowsEvent)
+	if !ok {
+		return false, fmt.Errorf("event is not a RowsEvent")
+	}
+	if !matchTable(rowsEvent) {
+		return true, nil
+	}
+	sqlType := eventTypeToString(e.Header.EventType)
+	if !cfg.SqlType.In(sqlType) {
+		return true, nil
+	}
+	t, err := eventTable(rowsEvent, cfg)
+	if err != nil {
+		return false, err
+	}
+	keys := t.columnIndexes(t.Pks)
+	if len(t.Pks) == 0 || keys == nil {
+		return false, nil
+	}
+	idx := s.table(t, keys)
+	position := fmt.Sprintf("%s:%d", file, e.Header.LogPos-e.Header.EventSize)
+	render := func(row []interface{}) []string {
+		values := make([]string, len(row))
+		for i, v := range row {
+			values[i] = t.formatValue(i, v)
+		}
+		return values
+	}
+	switch sqlType {
+	case "INSERT":
+		for i := 0; i < len(rowsEvent.Rows) && err == nil; i++ {
+			err = s.add(idx, nil, render(rowsEvent.Rows[i]), position)
+		}
+	case "DELETE":
+		for i := 0; i < len(rowsEvent.Rows) && err == nil; i++ {
+			err = s.add(idx, render(rowsEvent.Rows[i]), nil, position)
+		}
+	case "UPDATE":
+		for i := 0; i+1 < len(rowsEvent.Rows) && err == nil; i += 2 {
+			before, after := render(rowsEvent.Rows[i]), render(rowsEvent.Rows[i+1])
+			if s.rowKey(idx, before) != s.rowKey(idx, after) {
+				// 主键被修改时视为删除旧行并插入新行
+				if err = s.add(idx, before, nil, position); err == nil {
+					err = s.add(idx, nil, after, position)
+				}
+			} else {
+				err = s.add(idx, before, after, position)
+			}
+		}
+	}
+	return true, err
+}
+
+func (s *Squasher) table(t *Table, keys []int) int {
+	sig := t.fullName() + "(" + t.quoteColumns(t.Columns) + ")"
+	if idx, ok := s.tableIdx[sig]; ok {
+		return idx
+	}
+	st := &squashTable{name: t.fullName(), columns: t.Columns, keys: keys, omit: make([]bool, len(t.Columns))}
+	for i, col := range t.Columns {
+		st.omit[i] = utils.Contains(t.noPkColumns(), col)
+	}
+	s.tables = append(s.tables, st)
+	s.tableIdx[sig] = len(s.tables) - 1
+	return len(s.tables) - 1
+}
+
+// rowKey 返回 表名、表结构快照、主键值 组成的键，按表名、主键值排序
+func (s *Squasher) rowKey(idx int, row []string) string {
+	st := s.tables[idx]
+	b := []byte(fmt.Sprintf("%s\x00%08d\x00", st.name, idx))
+	for _, i := range st.keys {
+		b = appendKeyValue(b, row[i])
+	}
+	return string(b)
+}
+
+// appendKeyValue 追加一个主键值，使键的字节序与主键值的顺序一致：十进制数值按大小精确排序(大小相同时按字面量)，
+// 其他值按字面量排序。字面量中的 \x00 转义为 \x00\xff，以 \x00\x01 结尾
+func appendKeyValue(b []byte, literal string) []byte {
+	if neg, exp, digits, ok := parseDecimal(literal); !ok {
+		b = append(b, 4)
+	} else if len(digits) == 0 {
+		b = append(b, 2)
+	} else {
+		// 数值为 0.digits * 10^exp，先比较指数再逐位比较有效数字；负数各部分取反
+		e := uint32(exp) ^ 1<<31
+		if neg {
+			b = binary.BigEndian.AppendUint32(append(b, 1), ^e)
+			for i := 0; i < len(digits); i++ {
+				b = append(b, ^digits[i])
+			}
+			b = append(b, 0xff)
+		} else {
+			b = binary.BigEndian.AppendUint32(append(b, 3), e)
+			b = append(append(b, digits...), 0)
+		}
+	}
+	for i := 0; i < len(literal); i++ {
+		if literal[i] == 0 {
+			b = append(b, 0, 0xff)
+		} else {
+			b = append(b, literal[i])
+		}
+	}
+	return append(b, 0, 1)
+}
+
+// parseDecimal 解析十进制数值字面量(如 -12、3.50、1e+20)，返回符号、指数及去掉首尾0的有效数字，
+// 数值为 0.digits * 10^exp，为0时 digits 为空
+func parseDecimal(literal string) (neg bool, exp int, digits string, ok bool) {
+	s := literal
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg, s = s[0] == '-', s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil || e > math.MaxInt32/2 || e < math.MinInt32/2 {
+			return false, 0, "", false
+		}
+		exp, intPart = e, s[:i]
+	}
+	if i := strings.IndexByte(intPart, '.'); i >= 0 {
+		intPart, fracPart = intPart[:i], intPart[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return false, 0, "", false
+	}
+	for _, part := range []string{intPart, fracPart} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return false, 0, "", false
+			}
+		}
+	}
+	exp += len(intPart)
+	digits = intPart + fracPart
+	for len(digits) != 0 && digits[0] == '0' {
+		digits, exp = digits[1:], exp-1
+	}
+	return neg, exp, strings.TrimRight(digits, "0"), true
+}
+
+// add 合并一次修改，before 为nil表示INSERT，after 为nil表示DELETE
+func (s *Squasher) add(idx int, before, after []string, position string) error {
+	row := after
+	if row == nil {
+		row = before
+	}
+	key := s.rowKey(idx, row)
+	n, ok := s.rows[key]
+	if !ok {
+		n = &netChange{key: key, table: idx, hasBefore: before != nil, before: before, first: position}
+		s.rows[key] = n
+	}
+	n.hasAfter, n.after = after != nil, after
+	n.count++
+	n.last = position
+	if len(s.rows) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *Squasher) sortedRows() []*netChange {
+	rows := make([]*netChange, 0, len(s.rows))
+	for _, n := range s.rows {
+		rows = append(rows, n)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].key < rows[j].key })
+	return rows
+}
+
+// spill 将内存中的净变更按键排序后写入临时文件
+func (s *Squasher) spill() error {
+	f, err := os.CreateTemp("", "binlog2sql_go_squash_*")
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, f.Name())
+	w := &chunkWriter{w: bufio.NewWriter(f)}
+	for _, n := range s.sortedRows() {
+		writeNetChange(w, n)
+	}
+	err = w.err
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	s.rows = make(map[string]*netChange)
+	return err
+}
+
+// writeNetChange 使用与回滚SQL落盘相同的记录格式写入一条净变更
+func writeNetChange(w *chunkWriter, n *netChange) {
+	var flags uint32
+	if n.hasBefore {
+		flags |= 1
+	}
+	if n.hasAfter {
+		flags |= 2
+	}
+	w.string(n.key)
+	w.uint32(uint32(n.table))
+	w.uint32(flags)
+	for _, values := range [][]string{n.before, n.after} {
+		w.uint32(uint32(len(values)))
+		for _, v := range values {
+			w.string(v)
+		}
+	}
+	w.uint32(uint32(n.count))
+	w.string(n.first)
+	w.string(n.last)
+}
+
+func readNetChange(r *chunkReader) (*netChange, error) {
+	n := &netChange{key: r.string()}
+	if r.err != nil {
+		return nil, r.err
+	}
+	n.table = int(r.uint32())
+	flags := r.uint32()
+	n.hasBefore, n.hasAfter = flags&1 != 0, flags&2 != 0
+	for _, values := range []*[]string{&n.before, &n.after} {
+		for i := r.uint32(); i > 0 && r.err == nil; i-- {
+			*values = append(*values, r.string())
+		}
+	}
+	n.count = int(r.uint32())
+	n.first = r.string()
+	n.last = r.string()
+	if err := r.unexpectedEOF(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// merge 合并同一行的两段净变更，b 在 a 之后
+func (a *netChange) merge(b *netChange) {
+	a.hasAfter, a.after = b.hasAfter, b.after
+	a.count += b.count
+	a.last = b.last
+}
+
+type squashRun struct {
+	r   *chunkReader
+	f   *os.File
+	seq int
+	cur *netChange
+}
+
+type squashHeap []*squashRun
+
+func (h squashHeap) Len() int { return len(h) }
+func (h squashHeap) Less(i, j int) bool {
+	if h[i].cur.key != h[j].cur.key {
+		return h[i].cur.key < h[j].cur.key
+	}
+	return h[i].seq < h[j].seq
+}
+func (h squashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *squashHeap) Push(x interface{}) { *h = append(*h, x.(*squashRun)) }
+func (h *squashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// eachNetChange 按键的顺序遍历所有合并后的净变更
+func (s *Squasher) eachNetChange(fn func(n *netChange) error) error {
+	if len(s.files) == 0 {
+		for _, n := range s.sortedRows() {
+			if err := fn(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.rows) != 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	h := &squashHeap{}
+	defer func() {
+		for _, run := range *h {
+			run.f.Close()
+		}
+	}()
+	for i, name := range s.files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		run := &squashRun{r: &chunkReader{r: bufio.NewReader(f)}, f: f, seq: i}
+		if run.cur, err = readNetChange(run.r); err == io.EOF {
+			f.Close()
+			continue
+		} else if err != nil {
+			f.Close()
+			return err
+		}
+		heap.Push(h, run)
+	}
+	var pending *netChange
+	for h.Len() != 0 {
+		run := (*h)[0]
+		n := run.cur
+		var err error
+		if run.cur, err = readNetChange(run.r); err == io.EOF {
+			heap.Pop(h)
+			run.f.Close()
+		} else if err != nil {
+			return err
+		} else {
+			heap.Fix(h, 0)
+		}
+		if pending != nil && pending.key == n.key {
+			pending.merge(n)
+			continue
+		}
+		if pending != nil {
+			if err = fn(pending); err != nil {
+				return err
+			}
+		}
+		pending = n
+	}
+	if pending != nil {
+		return fn(pending)
+	}
+	return nil
+}
+
+// Each 按 表+主键 的顺序输出净变更的SQL，flashback 为true时输出撤销净变更的SQL
+func (s *Squasher) Each(cfg *conf.Config, fn func(sql string) error) error {
+	return s.eachNetChange(func(n *netChange) error {
+		if sql := s.tables[n.table].sql(n, cfg); sql != "" {
+			return fn(sql)
+		}
+		return nil
+	})
+}
+
+// Close 清理落盘的临时文件
+func (s *Squasher) Close() error {
+	var err error
+	for _, name := range s.files {
+		if rerr := os.Remove(name); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	s.files = nil
+	s.rows = nil
+	return err
+}
+
+// sql 生成净变更的SQL，回滚时交换修改前后的数据
+func (st *squashTable) sql(n *netChange, cfg *conf.Config) string {
+	hasBefore, hasAfter, before, after := n.hasBefore, n.hasAfter, n.before, n.after
+	if cfg.Flashback {
+		hasBefore, hasAfter, before, after = hasAfter, hasBefore, after, before
+	}
+	var sql string
+	switch {
+	case !hasBefore && hasAfter:
+		var columns, values []string
+		for i, col := range st.columns {
+			if cfg.NoPk && st.omit[i] {
+				continue
+			}
+			columns = append(columns, QuoteIdent(col))
+			values = append(values, after[i])
+		}
+		sql = fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s);", st.name, strings.Join(columns, ","), strings.Join(values, ","))
+	case hasBefore && !hasAfter:
+		sql = fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT 1;", st.name, st.where(before, cfg))
+	case hasBefore && hasAfter:
+		var set []string
+		for i, col := range st.columns {
+			if cfg.Simple && before[i] == after[i] && !st.isKey(i) {
+				continue
+			}
+			set = append(set, QuoteIdent(col)+"="+after[i])
+		}
+		if strings.Join(before, "\x00") == strings.Join(after, "\x00") {
+			return ""
+		}
+		sql = fmt.Sprintf("UPDATE %s SET %s WHERE %s LIMIT 1;", st.name, strings.Join(set, ","), st.where(before, cfg))
+	default:
+		return ""
+	}
+	return fmt.Sprintf("%s #squash %d changes first %s last %s", sql, n.count, n.first, n.last)
+}
+
+func (st *squashTable) isKey(i int) bool {
+	for _, k := range st.keys {
+		if k == i {
+			return true
+		}
+	}
+	return false
+}
+
+// where 生成定位一行的条件，-where-mode full 时使用整行，否则使用主键
+func (st *squashTable) where(row []string, cfg *conf.Config) string {
+	var condition []string
+	for i, col := range st.columns {
+		if cfg.WhereMode != "full" && !st.isKey(i) {
+			continue
+		}
+		if row[i] == "NULL" {
+			condition = append(condition, QuoteIdent(col)+" IS NULL")
+		} else {
+			condition = append(condition, QuoteIdent(col)+"="+row[i])
+		}
+	}
+	return strings.Join(condition, " AND ")
+}
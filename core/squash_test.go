@@ -0,0 +1,181 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestSquasher(t *testing.T) {
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 40},
+		ColumnName:  [][]byte{[]byte("id"), []byte("name")},
+		PrimaryKey:  []uint64{0},
+	}
+	logPos := uint32(100)
+	event := func(tp replication.EventType, rows ...[]interface{}) *replication.BinlogEvent {
+		logPos += 100
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: tp, LogPos: logPos, EventSize: 100},
+			Event:  &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 2, Rows: rows},
+		}
+	}
+	row := func(id int32, name interface{}) []interface{} {
+		return []interface{}{id, name}
+	}
+	events := []*replication.BinlogEvent{
+		// 1: 插入后多次修改再删除，没有净变更
+		event(replication.WRITE_ROWS_EVENTv2, row(1, "a"), row(2, "b")),
+		event(replication.UPDATE_ROWS_EVENTv2, row(1, "a"), row(1, "a2")),
+		// 3: 已存在的行被修改两次
+		event(replication.UPDATE_ROWS_EVENTv2, row(3, "c"), row(3, "c2")),
+		event(replication.UPDATE_ROWS_EVENTv2, row(1, "a2"), row(1, "a3"), row(3, "c2"), row(3, nil)),
+		event(replication.DELETE_ROWS_EVENTv2, row(1, "a3")),
+		// 4: 修改后又改回原值，没有净变更；5 的主键被改为 6
+		event(replication.UPDATE_ROWS_EVENTv2, row(4, "d"), row(4, "x"), row(5, "e"), row(6, "e")),
+		event(replication.UPDATE_ROWS_EVENTv2, row(4, "x"), row(4, "d")),
+		// 2: 插入后修改
+		event(replication.UPDATE_ROWS_EVENTv2, row(2, "b"), row(2, "b2")),
+	}
+	cfg := &conf.Config{WhereMode: "auto"}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	for _, limit := range []int{0, 2} {
+		s := NewSquasher(limit)
+		for _, e := range events {
+			if handled, err := s.AddRowsEvent(e, cfg, "mysql-bin.000001"); err != nil || !handled {
+				t.Fatalf("handled %v, err %v", handled, err)
+			}
+		}
+		if limit == 2 && len(s.files) == 0 {
+			t.Fatal("expect spilled files")
+		}
+		collect := func(flashback bool) (res []string) {
+			cfg.Flashback = flashback
+			if err := s.Each(cfg, func(sql string) error {
+				res = append(res, sql)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		want := []string{
+			"INSERT INTO `test`.`t`(`id`,`name`) VALUES(2,'b2'); #squash 2 changes first mysql-bin.000001:100 last mysql-bin.000001:800",
+			"UPDATE `test`.`t` SET `id`=3,`name`=NULL WHERE `id`=3 LIMIT 1; #squash 2 changes first mysql-bin.000001:300 last mysql-bin.000001:400",
+			"DELETE FROM `test`.`t` WHERE `id`=5 LIMIT 1; #squash 1 changes first mysql-bin.000001:600 last mysql-bin.000001:600",
+			"INSERT INTO `test`.`t`(`id`,`name`) VALUES(6,'e'); #squash 1 changes first mysql-bin.000001:600 last mysql-bin.000001:600",
+		}
+		if got := collect(false); !reflect.DeepEqual(got, want) {
+			t.Fatalf("limit %d got:\n%q\nwant:\n%q", limit, got, want)
+		}
+		want = []string{
+			"DELETE FROM `test`.`t` WHERE `id`=2 LIMIT 1; #squash 2 changes first mysql-bin.000001:100 last mysql-bin.000001:800",
+			"UPDATE `test`.`t` SET `id`=3,`name`='c' WHERE `id`=3 LIMIT 1; #squash 2 changes first mysql-bin.000001:300 last mysql-bin.000001:400",
+			"INSERT INTO `test`.`t`(`id`,`name`) VALUES(5,'e'); #squash 1 changes first mysql-bin.000001:600 last mysql-bin.000001:600",
+			"DELETE FROM `test`.`t` WHERE `id`=6 LIMIT 1; #squash 1 changes first mysql-bin.000001:600 last mysql-bin.000001:600",
+		}
+		if got := collect(true); !reflect.DeepEqual(got, want) {
+			t.Fatalf("limit %d flashback got:\n%q\nwant:\n%q", limit, got, want)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSquasherKeyOrder(t *testing.T) {
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 1,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG},
+		ColumnMeta:  []uint16{0},
+		ColumnName:  [][]byte{[]byte("id")},
+		PrimaryKey:  []uint64{0},
+	}
+	cfg := &conf.Config{WhereMode: "auto"}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	// 按主键值而不是字面量排序，落盘归并后顺序相同
+	for _, limit := range []int{0, 2} {
+		s := NewSquasher(limit)
+		for _, id := range []int64{10, 9, -1, 100, -20, 2} {
+			e := &replication.BinlogEvent{
+				Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, LogPos: 200, EventSize: 100},
+				Event:  &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 1, Rows: [][]interface{}{{id}}},
+			}
+			if _, err := s.AddRowsEvent(e, cfg, "mysql-bin.000001"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var got []string
+		if err := s.eachNetChange(func(n *netChange) error {
+			got = append(got, n.after[0])
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"-20", "-1", "2", "9", "10", "100"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("limit %d got %v, want %v", limit, got, want)
+		}
+		s.Close()
+	}
+}
+
+func TestSquasherNoPk(t *testing.T) {
+	// 联合主键没有自增列，-noPK 时保留全部主键列
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("lines"),
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 0, 40},
+		ColumnName:  [][]byte{[]byte("order_id"), []byte("line_no"), []byte("sku")},
+		PrimaryKey:  []uint64{0, 1},
+	}
+	cfg := &conf.Config{WhereMode: "auto", NoPk: true}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	s := NewSquasher(0)
+	defer s.Close()
+	e := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, LogPos: 200, EventSize: 100},
+		Event:  &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 3, Rows: [][]interface{}{{int32(7), int32(1), "a"}}},
+	}
+	if _, err := s.AddRowsEvent(e, cfg, "mysql-bin.000001"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := s.Each(cfg, func(sql string) error {
+		got = append(got, sql)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"INSERT INTO `test`.`lines`(`order_id`,`line_no`,`sku`) VALUES(7,1,'a'); #squash 1 changes first mysql-bin.000001:100 last mysql-bin.000001:100"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_appendKeyValueOrder(t *testing.T) {
+	// 按数值从小到大排列，包括float64不能精确表示的BIGINT
+	ordered := []string{"-1e+20", "-9223372036854775808", "-9223372036854775807", "-9007199254740993", "-9007199254740992",
+		"-100", "-10.5", "-10", "-9", "-0.5", "0", "0.00", "1e-3", "0.5", "1", "1.0", "1.5", "9", "10", "1e+2",
+		"9007199254740992", "9007199254740993", "18446744073709551614", "18446744073709551615"}
+	for i := 1; i < len(ordered); i++ {
+		a, b := string(appendKeyValue(nil, ordered[i-1])), string(appendKeyValue(nil, ordered[i]))
+		if a >= b {
+			t.Errorf("key of %s >= key of %s", ordered[i-1], ordered[i])
+		}
+	}
+	// 非数值按字面量排序，排在数值之后
+	if string(appendKeyValue(nil, "'a'")) <= string(appendKeyValue(nil, "99")) {
+		t.Error("string key before number key")
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Transaction 收集一个事务中生成的SQL，-transaction 模式下以事务为单位输出
+type Transaction struct {
+	Gtid     string
+	Xid      uint64
+	StartPos uint32
+	EndPos   uint32
+	// Timestamp 为事务 BEGIN 事件的时间
+	Timestamp uint32
+	// EndTimestamp 为事务最后一个事件的时间
+	EndTimestamp uint32
+	// Rows 为事务修改的行数，Bytes 为事务中binlog事件的总大小，不受库表等过滤条件影响
+	Rows    int
+	Bytes   uint64
+	stmts   []string
+	changes []*RowChange
+}
+
+// TrxBoundary 按binlog顺序跟踪事务边界：事务从 BEGIN 开始(MariaDB 没有 BEGIN 事件，从非独立的GTID事件开始)，
+// 到 XID 或 COMMIT 结束。
+// 开始事件在 -start-file/-start-position/-start-datetime 之前(包括从事务中间开始解析)的事务不完整
+type TrxBoundary struct {
+	inRange bool
+}
+
+// Add 处理一个事件，返回事件是否开始了一个在解析范围内的事务
+func (b *TrxBoundary) Add(cfg *conf.Config, file string, e *replication.BinlogEvent) bool {
+	switch ev := e.Event.(type) {
+	case *replication.MariadbGTIDEvent:
+		if ev.IsStandalone() {
+			return false
+		}
+		b.inRange = beginInRange(cfg, file, e)
+		return b.inRange
+	case *replication.XIDEvent:
+		b.inRange = false
+	case *replication.QueryEvent:
+		switch string(ev.Query) {
+		case "BEGIN":
+			b.inRange = beginInRange(cfg, file, e)
+			return b.inRange
+		case "COMMIT":
+			b.inRange = false
+		}
+	}
+	return false
+}
+
+// Partial 返回当前事件是否在不完整的事务中，以事务为单位处理时跳过其中的行事件
+func (b *TrxBoundary) Partial() bool {
+	return !b.inRange
+}
+
+// beginInRange 判断事务的开始事件是否在解析范围内，起始位置按事件的起始位置比较
+func beginInRange(cfg *conf.Config, file string, e *replication.BinlogEvent) bool {
+	if !cfg.StartDatetime.IsZero() && cfg.EventTime(e.Header.Timestamp).Before(cfg.StartDatetime) {
+		return false
+	}
+	return file != cfg.StartFile || e.Header.LogPos-e.Header.EventSize >= uint32(cfg.StartPosition)
+}
+
+// AddEvent 累计事务的行数、大小和结束时间，事务中的每个事件都需要调用
+func (t *Transaction) AddEvent(e *replication.BinlogEvent) {
+	t.Bytes += uint64(e.Header.EventSize)
+	t.EndTimestamp = e.Header.Timestamp
+	if re, ok := e.Event.(*replication.RowsEvent); ok {
+		switch e.Header.EventType {
+		case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+			t.Rows += len(re.Rows) / 2
+		default:
+			t.Rows += len(re.Rows)
+		}
+	}
+}
+
+// Duration 返回事务从 BEGIN 到最后一个事件的时间，binlog中的时间精度为秒
+func (t *Transaction) Duration() time.Duration {
+	if t.EndTimestamp < t.Timestamp {
+		return 0
+	}
+	return time.Duration(t.EndTimestamp-t.Timestamp) * time.Second
+}
+
+// Match 判断事务是否满足 -min-rows/-min-bytes/-min-duration 条件
+func (t *Transaction) Match(cfg *conf.Config) bool {
+	return t.Rows >= int(cfg.MinRows) && t.Bytes >= uint64(cfg.MinBytes) && t.Duration() >= cfg.MinDuration
+}
+
+// Stmts 返回按binlog顺序收集的SQL
+func (t *Transaction) Stmts() []string {
+	return t.stmts
+}
+
+// Changes 返回按binlog顺序收集的行变更，并填写事务的XID
+func (t *Transaction) Changes() []*RowChange {
+	for _, c := range t.changes {
+		c.Xid = t.Xid
+	}
+	return t.changes
+}
+
+// Add 按binlog顺序追加一条（可以是多行的）SQL
+func (t *Transaction) Add(sql string) {
+	t.stmts = append(t.stmts, sql)
+}
+
+// AddChange 按binlog顺序追加行变更，用于结构化输出
+func (t *Transaction) AddChange(changes ...*RowChange) {
+	t.changes = append(t.changes, changes...)
+}
+
+func (t *Transaction) Len() int {
+	return len(t.stmts) + len(t.changes)
+}
+
+// Body 返回事务中的SQL，每条一行，flashback 为true时按从新到旧的顺序
+func (t *Transaction) Body(flashback bool) string {
+	var b strings.Builder
+	for i := range t.stmts {
+		if flashback {
+			i = len(t.stmts) - 1 - i
+		}
+		if b.Len() != 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(t.stmts[i])
+	}
+	return b.String()
+}
+
+// String 生成用 BEGIN;/COMMIT; 包裹的事务，回滚时事务内的SQL按从新到旧的顺序输出
+func (t *Transaction) String(cfg *conf.Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "BEGIN; #start %v time %v", t.StartPos, cfg.EventTime(t.Timestamp).Format("2006-01-02 15:04:05"))
+	if t.Gtid != "" {
+		fmt.Fprintf(&b, " gtid %s", t.Gtid)
+	}
+	fmt.Fprintf(&b, "\n%s\nCOMMIT; #xid %v end %v", t.Body(cfg.Flashback), t.Xid, t.EndPos)
+	return b.String()
+}
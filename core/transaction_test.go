@@ -0,0 +1,146 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestTransactionString(t *testing.T) {
+	ts := time.Unix(1700000000, 0).Format("2006-01-02 15:04:05")
+	trx := &Transaction{Gtid: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23", Xid: 12, StartPos: 100, EndPos: 500, Timestamp: 1700000000}
+	trx.Add("INSERT INTO `db`.`t`(`id`) VALUES (1); #start 180 end 260")
+	trx.Add("UPDATE `db`.`t` SET `id`=2 WHERE `id`=1 LIMIT 1; #start 260 end 340")
+	want := "BEGIN; #start 100 time " + ts + " gtid 3e11fa47-71ca-11e1-9e33-c80aa9429562:23\n" +
+		"INSERT INTO `db`.`t`(`id`) VALUES (1); #start 180 end 260\n" +
+		"UPDATE `db`.`t` SET `id`=2 WHERE `id`=1 LIMIT 1; #start 260 end 340\n" +
+		"COMMIT; #xid 12 end 500"
+	if got := trx.String(&conf.Config{}); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	want = "BEGIN; #start 100 time " + ts + " gtid 3e11fa47-71ca-11e1-9e33-c80aa9429562:23\n" +
+		"UPDATE `db`.`t` SET `id`=2 WHERE `id`=1 LIMIT 1; #start 260 end 340\n" +
+		"INSERT INTO `db`.`t`(`id`) VALUES (1); #start 180 end 260\n" +
+		"COMMIT; #xid 12 end 500"
+	if got := trx.String(&conf.Config{Flashback: true}); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTransactionMatch(t *testing.T) {
+	trx := &Transaction{Timestamp: 100}
+	trx.AddEvent(&replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, Timestamp: 100, EventSize: 1000},
+		Event:  &replication.RowsEvent{Rows: [][]interface{}{{1}, {2}, {3}}},
+	})
+	trx.AddEvent(&replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.UPDATE_ROWS_EVENTv2, Timestamp: 130, EventSize: 500},
+		Event:  &replication.RowsEvent{Rows: [][]interface{}{{1}, {4}, {2}, {5}}},
+	})
+	trx.AddEvent(&replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.XID_EVENT, Timestamp: 131, EventSize: 31},
+		Event:  &replication.XIDEvent{XID: 7},
+	})
+	if trx.Rows != 5 || trx.Bytes != 1531 || trx.Duration() != 31*time.Second {
+		t.Fatalf("rows %d bytes %d duration %v", trx.Rows, trx.Bytes, trx.Duration())
+	}
+	cases := []struct {
+		cfg  conf.Config
+		want bool
+	}{
+		{conf.Config{}, true},
+		{conf.Config{MinRows: 5}, true},
+		{conf.Config{MinRows: 6}, false},
+		{conf.Config{MinBytes: 1531}, true},
+		{conf.Config{MinBytes: 1532}, false},
+		{conf.Config{MinDuration: 30 * time.Second}, true},
+		{conf.Config{MinRows: 1, MinDuration: time.Minute}, false},
+	}
+	for i, c := range cases {
+		if got := trx.Match(&c.cfg); got != c.want {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestTrxBoundary(t *testing.T) {
+	event := func(tp replication.EventType, ev replication.Event, start, end uint32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{Header: &replication.EventHeader{EventType: tp, LogPos: end, EventSize: end - start, Timestamp: 100}, Event: ev}
+	}
+	begin := func(start, end uint32) *replication.BinlogEvent {
+		return event(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("BEGIN")}, start, end)
+	}
+	rows := func(start, end uint32) *replication.BinlogEvent {
+		return event(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{}, start, end)
+	}
+	xid := func(start, end uint32) *replication.BinlogEvent {
+		return event(replication.XID_EVENT, &replication.XIDEvent{}, start, end)
+	}
+	commit := func(start, end uint32) *replication.BinlogEvent {
+		return event(replication.QUERY_EVENT, &replication.QueryEvent{Query: []byte("COMMIT")}, start, end)
+	}
+	mariadbGtid := func(flags byte, start, end uint32) *replication.BinlogEvent {
+		return event(replication.MARIADB_GTID_EVENT, &replication.MariadbGTIDEvent{Flags: flags}, start, end)
+	}
+	type step struct {
+		e       *replication.BinlogEvent
+		begin   bool
+		partial bool
+	}
+	cases := []struct {
+		name  string
+		cfg   conf.Config
+		steps []step
+	}{
+		{"start at BEGIN", conf.Config{StartFile: "mysql-bin.000001", StartPosition: 165}, []step{
+			{begin(165, 245), true, false}, {rows(245, 300), false, false}, {xid(300, 331), false, true},
+		}},
+		// 起始位置在事务中间：本地文件从头读到该事务的 BEGIN，在线解析时直接从行事件开始
+		{"start inside transaction", conf.Config{StartFile: "mysql-bin.000001", StartPosition: 245}, []step{
+			{begin(165, 245), false, true}, {rows(245, 300), false, true}, {xid(300, 331), false, true},
+			{begin(331, 411), true, false}, {rows(411, 480), false, false}, {commit(480, 560), false, true},
+		}},
+		{"online start inside transaction", conf.Config{StartFile: "mysql-bin.000001", StartPosition: 245}, []step{
+			{rows(245, 300), false, true}, {xid(300, 331), false, true}, {begin(331, 411), true, false},
+		}},
+		// MariaDB 的事务以非独立的GTID事件开始，独立的GTID事件(DDL)不开始事务
+		{"mariadb", conf.Config{StartFile: "mysql-bin.000001", StartPosition: 4}, []step{
+			{mariadbGtid(replication.BINLOG_MARIADB_FL_STANDALONE|replication.BINLOG_MARIADB_FL_DDL, 100, 138), false, true},
+			{mariadbGtid(replication.BINLOG_MARIADB_FL_TRANSACTIONAL, 165, 203), true, false},
+			{rows(203, 300), false, false}, {xid(300, 331), false, true},
+		}},
+		{"mariadb start inside transaction", conf.Config{StartFile: "mysql-bin.000001", StartPosition: 203}, []step{
+			{mariadbGtid(replication.BINLOG_MARIADB_FL_TRANSACTIONAL, 165, 203), false, true},
+			{rows(203, 300), false, true}, {xid(300, 331), false, true},
+		}},
+		{"start datetime", conf.Config{StartDatetime: time.Unix(101, 0)}, []step{
+			{begin(165, 245), false, true}, {rows(245, 300), false, true},
+		}},
+	}
+	for _, c := range cases {
+		b := &TrxBoundary{}
+		for i, s := range c.steps {
+			if got := b.Add(&c.cfg, "mysql-bin.000001", s.e); got != s.begin || b.Partial() != s.partial {
+				t.Errorf("%s step %d: begin %v partial %v, want %v %v", c.name, i, got, b.Partial(), s.begin, s.partial)
+			}
+		}
+	}
+
+	// 不完整的事务只在以事务为单位处理时跳过，普通SQL输出仍按事件的位置过滤
+	for _, c := range []struct {
+		cfg  conf.Config
+		want bool
+	}{
+		{conf.Config{OutputFormat: "sql"}, false},
+		{conf.Config{OutputFormat: "sql", Transaction: true}, true},
+		{conf.Config{OutputFormat: "sql", MinRows: 10}, true},
+		{conf.Config{OutputFormat: "sql", ApplyTo: "root@tcp(127.0.0.1:3306)/"}, true},
+		{conf.Config{OutputFormat: "json"}, true},
+	} {
+		if got := c.cfg.WholeTransaction(); got != c.want {
+			t.Errorf("%+v: whole transaction %v, want %v", c.cfg, got, c.want)
+		}
+	}
+}
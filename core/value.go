@@ -0,0 +1,424 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/shopspring/decimal"
+)
+
+// binaryCollationId 为 binary 字符集的排序规则id，TableMapEvent中该排序规则的字符列实际是二进制列
+const binaryCollationId = 63
+
+// columnType 返回 information_schema.columns.column_type 中的类型定义（小写），未知时返回空
+func (t *Table) columnType(i int) string {
+	if i < len(t.ColumnTypes) {
+		return strings.ToLower(t.ColumnTypes[i])
+	}
+	return ""
+}
+
+// realType 返回TableMapEvent中第i列的实际类型及元数据，ENUM/SET在binlog中被记录为 MYSQL_TYPE_STRING
+func (t *Table) realType(i int) (tp byte, meta uint16, ok bool) {
+	if t.Meta == nil || i >= len(t.Meta.ColumnType) {
+		return
+	}
+	tp, meta = t.Meta.ColumnType[i], t.Meta.ColumnMeta[i]
+	if tp == mysql.MYSQL_TYPE_STRING && meta >= 256 {
+		if rtp := byte(meta >> 8); rtp == mysql.MYSQL_TYPE_ENUM || rtp == mysql.MYSQL_TYPE_SET {
+			tp = rtp
+		}
+	}
+	return tp, meta, true
+}
+
+// isInteger 判断列是否为整数类型
+func (t *Table) isInteger(i int) bool {
+	if ct := t.columnType(i); ct != "" {
+		for _, prefix := range []string{"tinyint", "smallint", "mediumint", "int", "bigint"} {
+			if strings.HasPrefix(ct, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	tp, _, ok := t.realType(i)
+	return ok && (tp == mysql.MYSQL_TYPE_TINY || tp == mysql.MYSQL_TYPE_SHORT || tp == mysql.MYSQL_TYPE_INT24 ||
+		tp == mysql.MYSQL_TYPE_LONG || tp == mysql.MYSQL_TYPE_LONGLONG)
+}
+
+// binlogColumnTypes 为 column_type 的类型名对应的TableMapEvent中的类型，部分类型在旧版本或MariaDB中有不同的记录方式
+var binlogColumnTypes = map[string][]byte{
+	"tinyint":            {mysql.MYSQL_TYPE_TINY},
+	"bool":               {mysql.MYSQL_TYPE_TINY},
+	"boolean":            {mysql.MYSQL_TYPE_TINY},
+	"smallint":           {mysql.MYSQL_TYPE_SHORT},
+	"mediumint":          {mysql.MYSQL_TYPE_INT24},
+	"int":                {mysql.MYSQL_TYPE_LONG},
+	"integer":            {mysql.MYSQL_TYPE_LONG},
+	"bigint":             {mysql.MYSQL_TYPE_LONGLONG},
+	"float":              {mysql.MYSQL_TYPE_FLOAT},
+	"double":             {mysql.MYSQL_TYPE_DOUBLE},
+	"real":               {mysql.MYSQL_TYPE_DOUBLE},
+	"decimal":            {mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_DECIMAL},
+	"numeric":            {mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_DECIMAL},
+	"bit":                {mysql.MYSQL_TYPE_BIT},
+	"year":               {mysql.MYSQL_TYPE_YEAR},
+	"date":               {mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE},
+	"time":               {mysql.MYSQL_TYPE_TIME2, mysql.MYSQL_TYPE_TIME},
+	"datetime":           {mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_DATETIME},
+	"timestamp":          {mysql.MYSQL_TYPE_TIMESTAMP2, mysql.MYSQL_TYPE_TIMESTAMP},
+	"char":               {mysql.MYSQL_TYPE_STRING},
+	"binary":             {mysql.MYSQL_TYPE_STRING},
+	"varchar":            {mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING},
+	"varbinary":          {mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING},
+	"tinytext":           {mysql.MYSQL_TYPE_BLOB},
+	"text":               {mysql.MYSQL_TYPE_BLOB},
+	"mediumtext":         {mysql.MYSQL_TYPE_BLOB},
+	"longtext":           {mysql.MYSQL_TYPE_BLOB},
+	"tinyblob":           {mysql.MYSQL_TYPE_BLOB},
+	"blob":               {mysql.MYSQL_TYPE_BLOB},
+	"mediumblob":         {mysql.MYSQL_TYPE_BLOB},
+	"longblob":           {mysql.MYSQL_TYPE_BLOB},
+	"json":               {mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_BLOB},
+	"enum":               {mysql.MYSQL_TYPE_ENUM},
+	"set":                {mysql.MYSQL_TYPE_SET},
+	"geometry":           {mysql.MYSQL_TYPE_GEOMETRY},
+	"point":              {mysql.MYSQL_TYPE_GEOMETRY},
+	"linestring":         {mysql.MYSQL_TYPE_GEOMETRY},
+	"polygon":            {mysql.MYSQL_TYPE_GEOMETRY},
+	"multipoint":         {mysql.MYSQL_TYPE_GEOMETRY},
+	"multilinestring":    {mysql.MYSQL_TYPE_GEOMETRY},
+	"multipolygon":       {mysql.MYSQL_TYPE_GEOMETRY},
+	"geometrycollection": {mysql.MYSQL_TYPE_GEOMETRY},
+	"geomcollection":     {mysql.MYSQL_TYPE_GEOMETRY},
+}
+
+// columnTypeMatches 判断 column_type 与TableMapEvent中的类型是否一致，未知的类型(如MariaDB的uuid)视为一致
+func columnTypeMatches(columnType string, tp byte) bool {
+	name := columnType
+	if i := strings.IndexAny(name, "( "); i >= 0 {
+		name = name[:i]
+	}
+	types, ok := binlogColumnTypes[name]
+	return !ok || strings.IndexByte(string(types), tp) >= 0
+}
+
+// binlogTypeName 返回TableMapEvent中类型的名字，用于错误信息
+func binlogTypeName(tp byte) string {
+	for _, name := range []string{"tinyint", "smallint", "mediumint", "int", "bigint", "float", "double", "decimal", "bit", "year", "date",
+		"time", "datetime", "timestamp", "char", "varchar", "blob", "json", "enum", "set", "geometry"} {
+		if types := binlogColumnTypes[name]; strings.IndexByte(string(types), tp) >= 0 {
+			return name
+		}
+	}
+	return fmt.Sprintf("type %d", tp)
+}
+
+func (t *Table) isUnsigned(i int) bool {
+	if ct := t.columnType(i); ct != "" {
+		return strings.Contains(ct, "unsigned")
+	}
+	if t.Meta != nil {
+		if t.unsigned == nil {
+			t.unsigned = t.Meta.UnsignedMap()
+		}
+		return t.unsigned[i]
+	}
+	return false
+}
+
+// isBinary 判断字符串/BLOB类的列是否存储二进制数据
+func (t *Table) isBinary(i int) (binary bool, known bool) {
+	if ct := t.columnType(i); ct != "" {
+		for _, prefix := range []string{"binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "geometry", "point", "linestring", "polygon", "multi", "geometrycollection", "geomcollection"} {
+			if strings.HasPrefix(ct, prefix) {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	if t.Meta != nil {
+		if t.collations == nil {
+			t.collations = t.Meta.CollationMap()
+		}
+		if collation, ok := t.collations[i]; ok {
+			return collation == binaryCollationId, true
+		}
+	}
+	return false, false
+}
+
+// enumSetValues 返回ENUM/SET列的可选值，优先使用 column_type，其次使用 binlog_row_metadata=FULL 时记录的值
+func (t *Table) enumSetValues(i int, tp byte) []string {
+	if ct := t.columnType(i); strings.HasPrefix(ct, "enum(") || strings.HasPrefix(ct, "set(") {
+		// 取原始大小写的定义
+		return parseEnumSetValues(t.ColumnTypes[i])
+	}
+	if t.Meta == nil {
+		return nil
+	}
+	if tp == mysql.MYSQL_TYPE_ENUM {
+		if t.enums == nil {
+			t.enums = t.Meta.EnumStrValueMap()
+		}
+		return t.enums[i]
+	}
+	if t.sets == nil {
+		t.sets = t.Meta.SetStrValueMap()
+	}
+	return t.sets[i]
+}
+
+// parseEnumSetValues 解析 enum('a','b') 形式的类型定义，值中的单引号在定义中被写成两个单引号
+func parseEnumSetValues(columnType string) (values []string) {
+	start, end := strings.IndexByte(columnType, '('), strings.LastIndexByte(columnType, ')')
+	if start < 0 || end <= start {
+		return nil
+	}
+	s := columnType[start+1 : end]
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case !inQuote && c == '\'':
+			inQuote = true
+		case inQuote && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			cur.WriteByte('\'')
+			i++
+		case inQuote && c == '\\' && i+1 < len(s):
+			cur.WriteByte(s[i+1])
+			i++
+		case inQuote && c == '\'':
+			inQuote = false
+			values = append(values, cur.String())
+			cur.Reset()
+		case inQuote:
+			cur.WriteByte(c)
+		}
+	}
+	return
+}
+
+func hexLiteral(b []byte) string {
+	if len(b) == 0 {
+		return "''"
+	}
+	return "X'" + strings.ToUpper(hex.EncodeToString(b)) + "'"
+}
+
+// toUnsigned 按列宽把binlog中解析出的有符号整数还原为无符号整数
+func toUnsigned(tp byte, v interface{}) (uint64, bool) {
+	switch val := v.(type) {
+	case int8:
+		return uint64(uint8(val)), true
+	case int16:
+		return uint64(uint16(val)), true
+	case int32:
+		if tp == mysql.MYSQL_TYPE_INT24 {
+			return uint64(uint32(val) & 0xFFFFFF), true
+		}
+		return uint64(uint32(val)), true
+	case int64:
+		return uint64(val), true
+	}
+	return 0, false
+}
+
+func formatTime(tp byte, meta uint16, v time.Time) string {
+	layout := "2006-01-02 15:04:05"
+	if tp == mysql.MYSQL_TYPE_DATE || tp == mysql.MYSQL_TYPE_NEWDATE {
+		layout = "2006-01-02"
+	} else if (tp == mysql.MYSQL_TYPE_DATETIME2 || tp == mysql.MYSQL_TYPE_TIMESTAMP2) && meta > 0 && meta <= 6 {
+		layout += "." + strings.Repeat("0", int(meta))
+	}
+	return "'" + v.Format(layout) + "'"
+}
+
+// formatValue 根据列类型将第i列的值转换为SQL字面量
+func (t *Table) formatValue(i int, v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	tp, meta, typed := t.realType(i)
+	if typed {
+		switch tp {
+		case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG:
+			if t.isUnsigned(i) {
+				if u, ok := toUnsigned(tp, v); ok {
+					return strconv.FormatUint(u, 10)
+				}
+			}
+		case mysql.MYSQL_TYPE_NEWDECIMAL:
+			if d, ok := v.(decimal.Decimal); ok {
+				return d.StringFixed(int32(meta & 0xFF))
+			}
+		case mysql.MYSQL_TYPE_BIT:
+			if b, ok := v.(int64); ok {
+				nbits := int((meta>>8)*8 + meta&0xFF)
+				s := strconv.FormatUint(uint64(b), 2)
+				if len(s) < nbits {
+					s = strings.Repeat("0", nbits-len(s)) + s
+				}
+				return "b'" + s + "'"
+			}
+		case mysql.MYSQL_TYPE_ENUM:
+			if idx, ok := v.(int64); ok {
+				values := t.enumSetValues(i, tp)
+				if idx == 0 {
+					// 非法值写入时MySQL存储为下标0，即空字符串
+					return "''"
+				}
+				if int(idx) <= len(values) {
+					return QuoteString(values[idx-1], t.NoBackslashEscapes)
+				}
+			}
+		case mysql.MYSQL_TYPE_SET:
+			if bits, ok := v.(int64); ok {
+				if values := t.enumSetValues(i, tp); values != nil {
+					var members []string
+					for j, name := range values {
+						if bits&(1<<uint(j)) != 0 {
+							members = append(members, name)
+						}
+					}
+					return QuoteString(strings.Join(members, ","), t.NoBackslashEscapes)
+				}
+			}
+		case mysql.MYSQL_TYPE_JSON:
+			switch val := v.(type) {
+			case string:
+				return fmt.Sprintf("CAST(%s AS JSON)", QuoteString(val, t.NoBackslashEscapes))
+			case []byte:
+				return fmt.Sprintf("CAST(%s AS JSON)", QuoteString(string(val), t.NoBackslashEscapes))
+			}
+		case mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY:
+			binary, known := t.isBinary(i)
+			if !known {
+				// BLOB/GEOMETRY 在未知字符集时按二进制处理，十六进制字面量对文本列同样有效
+				binary = tp == mysql.MYSQL_TYPE_BLOB || tp == mysql.MYSQL_TYPE_GEOMETRY
+			}
+			switch val := v.(type) {
+			case string:
+				if binary || !utf8.ValidString(val) {
+					return hexLiteral([]byte(val))
+				}
+			case []byte:
+				if binary || !utf8.Valid(val) {
+					return hexLiteral(val)
+				}
+				return QuoteString(string(val), t.NoBackslashEscapes)
+			}
+		}
+	}
+	switch val := v.(type) {
+	case string:
+		return QuoteString(val, t.NoBackslashEscapes)
+	case []byte:
+		return hexLiteral(val)
+	case decimal.Decimal:
+		return val.String()
+	case float32:
+		return strconv.FormatFloat(float64(val), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case time.Time:
+		return formatTime(tp, meta, val)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// plainValue 根据列类型将第i列的值转换为结构化输出（如JSON）使用的值：无符号整数按无符号输出，
+// DECIMAL 为字符串，ENUM/SET 为名称，JSON列为原始JSON，二进制数据为[]byte，时间为字符串
+func (t *Table) plainValue(i int, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	tp, meta, typed := t.realType(i)
+	if typed {
+		switch tp {
+		case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_LONGLONG:
+			if t.isUnsigned(i) {
+				if u, ok := toUnsigned(tp, v); ok {
+					return u
+				}
+			}
+		case mysql.MYSQL_TYPE_NEWDECIMAL:
+			if d, ok := v.(decimal.Decimal); ok {
+				return d.StringFixed(int32(meta & 0xFF))
+			}
+		case mysql.MYSQL_TYPE_BIT:
+			if b, ok := v.(int64); ok {
+				return uint64(b)
+			}
+		case mysql.MYSQL_TYPE_ENUM:
+			if idx, ok := v.(int64); ok {
+				values := t.enumSetValues(i, tp)
+				if idx == 0 {
+					return ""
+				}
+				if int(idx) <= len(values) {
+					return values[idx-1]
+				}
+			}
+		case mysql.MYSQL_TYPE_SET:
+			if bits, ok := v.(int64); ok {
+				if values := t.enumSetValues(i, tp); values != nil {
+					var members []string
+					for j, name := range values {
+						if bits&(1<<uint(j)) != 0 {
+							members = append(members, name)
+						}
+					}
+					return strings.Join(members, ",")
+				}
+			}
+		case mysql.MYSQL_TYPE_JSON:
+			var raw []byte
+			switch val := v.(type) {
+			case string:
+				raw = []byte(val)
+			case []byte:
+				raw = val
+			}
+			if json.Valid(raw) {
+				return json.RawMessage(raw)
+			}
+		case mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY:
+			binary, known := t.isBinary(i)
+			if !known {
+				binary = tp == mysql.MYSQL_TYPE_BLOB || tp == mysql.MYSQL_TYPE_GEOMETRY
+			}
+			switch val := v.(type) {
+			case string:
+				if binary || !utf8.ValidString(val) {
+					return []byte(val)
+				}
+			case []byte:
+				if binary || !utf8.Valid(val) {
+					return val
+				}
+				return string(val)
+			}
+		}
+	}
+	switch val := v.(type) {
+	case []byte:
+		if utf8.Valid(val) {
+			return string(val)
+		}
+	case decimal.Decimal:
+		return val.String()
+	case time.Time:
+		return strings.Trim(formatTime(tp, meta, val), "'")
+	}
+	return v
+}
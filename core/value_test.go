@@ -0,0 +1,65 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/shopspring/decimal"
+)
+
+func Test_formatValue(t *testing.T) {
+	tab := &Table{
+		Schema:  "test",
+		Table:   "t",
+		Columns: []string{"id", "price", "bits", "state", "tags", "doc", "raw", "txt", "ts", "name", "bin"},
+		ColumnTypes: []string{"bigint(20) unsigned", "decimal(10,2)", "bit(5)", "enum('new','it''s done')", "set('a','b','c')",
+			"json", "blob", "text", "datetime(3)", "varchar(10)", "varbinary(4)"},
+		Meta: &replication.TableMapEvent{
+			ColumnCount: 11,
+			ColumnType: []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_BIT, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING,
+				mysql.MYSQL_TYPE_JSON, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_DATETIME2, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR},
+			ColumnMeta: []uint16{0, 10<<8 | 2, 0<<8 | 5, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1,
+				4, 2, 2, 3, 40, 4},
+		},
+	}
+	values := []interface{}{int64(-1), decimal.RequireFromString("12.5"), int64(5), int64(2), int64(5),
+		`{"a": "x'y"}`, []byte{0, 1, 0xff}, []byte("line\n"), time.Date(2023, 1, 2, 3, 4, 5, 120000000, time.UTC), "abc", "\x00\x01"}
+	want := []string{"18446744073709551615", "12.50", "b'00101'", `'it\'s done'`, "'a,c'",
+		`CAST('{\"a\": \"x\'y\"}' AS JSON)`, "X'0001FF'", `'line\n'`, "'2023-01-02 03:04:05.120'", "'abc'", "X'0001'"}
+	for i, v := range values {
+		if got := tab.formatValue(i, v); got != want[i] {
+			t.Errorf("%s: got %s want %s", tab.Columns[i], got, want[i])
+		}
+	}
+}
+
+func Test_formatValueFromTableMap(t *testing.T) {
+	// 没有 information_schema 的类型信息时，按 binlog_row_metadata=FULL 记录的元数据渲染
+	tab := &Table{
+		Columns: []string{"id", "small", "state", "b"},
+		Meta: &replication.TableMapEvent{
+			ColumnCount:      4,
+			ColumnType:       []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_STRING, mysql.MYSQL_TYPE_STRING},
+			ColumnMeta:       []uint16{0, 0, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, uint16(mysql.MYSQL_TYPE_STRING)<<8 | 16},
+			SignednessBitmap: []byte{0x80},
+			EnumStrValue:     [][][]byte{{[]byte("x"), []byte("y")}},
+			DefaultCharset:   []uint64{33, 0, 63},
+		},
+	}
+	values := []interface{}{int32(-2), int8(-1), int64(2), "ab"}
+	want := []string{"4294967294", "-1", "'y'", "X'6162'"}
+	for i, v := range values {
+		if got := tab.formatValue(i, v); got != want[i] {
+			t.Errorf("%s: got %s want %s", tab.Columns[i], got, want[i])
+		}
+	}
+}
+
+func Test_parseEnumSetValues(t *testing.T) {
+	got := parseEnumSetValues("enum('a','it''s','c,d')")
+	if len(got) != 3 || got[0] != "a" || got[1] != "it's" || got[2] != "c,d" {
+		t.Error(got)
+	}
+}
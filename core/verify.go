@@ -0,0 +1,215 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"binlog2sql_go/db"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Verifier 为 -verify 模式下回滚SQL的校验：检查回滚的 UPDATE/DELETE 要修改的行在当前库中是否仍是binlog中的样子。
+// 回滚按从新到旧的顺序执行，同一行被多次修改时只有最新的修改需要与当前数据比较，更早的修改由binlog的连续性保证
+type Verifier struct {
+	checks []verifyCheck
+	conn   *sql.Conn
+	// Matched 行与binlog一致；Changed 行存在但已被后续写入修改；Missing 行已不存在；Covered 同一行在解析范围内有更新的修改
+	Matched, Changed, Missing, Covered int
+}
+
+type verifyCheck struct {
+	t *Table
+	// sqlType 为回滚SQL的类型，UPDATE 或 DELETE；INSERT 为原始的 DELETE，只用于判断更早的修改是否被覆盖
+	sqlType string
+	// row 为回滚前该行应有的数据，即原始事件修改后的数据
+	row  []interface{}
+	file string
+	pos  uint32
+}
+
+// AddRowsEvent 记录RowsEvent中需要校验的行，库表及 -sql-type 过滤条件与生成SQL时相同。
+// 原始的 INSERT 回滚为 DELETE，原始的 UPDATE 回滚为 UPDATE，原始的 DELETE 回滚为 INSERT 不需要校验，
+// 但回滚时先重新插入该行，同一行更早的修改不再与当前数据比较
+func (v *Verifier) AddRowsEvent(e *replication.BinlogEvent, cfg *conf.Config, file string) error {
+	rowsEvent, ok := e.Event.(*replication.RowsEvent)
+	if !ok {
+		return fmt.Errorf("event is not a RowsEvent")
+	}
+	if !matchTable(rowsEvent) {
+		return nil
+	}
+	sqlType := eventTypeToString(e.Header.EventType)
+	if !cfg.SqlType.In(sqlType) {
+		return nil
+	}
+	t, err := eventTable(rowsEvent, cfg)
+	if err != nil {
+		return err
+	}
+	t.WhereKeys = t.keyColumns()
+	pos := e.Header.LogPos - e.Header.EventSize
+	switch sqlType {
+	case "INSERT":
+		for _, row := range rowsEvent.Rows {
+			v.checks = append(v.checks, verifyCheck{t: t, sqlType: "DELETE", row: row, file: file, pos: pos})
+		}
+	case "UPDATE":
+		for i := 1; i < len(rowsEvent.Rows); i += 2 {
+			v.checks = append(v.checks, verifyCheck{t: t, sqlType: "UPDATE", row: rowsEvent.Rows[i], file: file, pos: pos})
+		}
+	case "DELETE":
+		for _, row := range rowsEvent.Rows {
+			v.checks = append(v.checks, verifyCheck{t: t, sqlType: "INSERT", row: row, file: file, pos: pos})
+		}
+	}
+	return nil
+}
+
+// Connect 在解析之前打开校验使用的连接，会话的 time_zone 与 -time-zone 一致，
+// 使TIMESTAMP列的条件与生成SQL时按同一时区解释
+func (v *Verifier) Connect(cfg *conf.Config) error {
+	if db.Conn == nil {
+		return fmt.Errorf("-verify needs a database connection")
+	}
+	tz, err := mysqlTimeZone(cfg.Location())
+	if err != nil {
+		return err
+	}
+	conn, err := db.NewSession(tz)
+	if err != nil && tz != cfg.Location().String() {
+		return err
+	}
+	if err != nil {
+		// 服务器未加载时区表时只能使用固定的偏移
+		if tz, err = fixedTimeZone(cfg.Location()); err != nil {
+			return err
+		}
+		if conn, err = db.NewSession(tz); err != nil {
+			return err
+		}
+	}
+	v.conn = conn
+	return nil
+}
+
+// mysqlTimeZone 返回时区在MySQL中的写法：有名字的时区使用名字(需要服务器加载时区表)，否则为固定的偏移
+func mysqlTimeZone(loc *time.Location) (string, error) {
+	if loc == time.UTC {
+		return "+00:00", nil
+	}
+	if name := loc.String(); loc != time.Local && !strings.HasPrefix(name, "+") && !strings.HasPrefix(name, "-") {
+		return name, nil
+	}
+	return fixedTimeZone(loc)
+}
+
+// fixedTimeZone 返回没有夏令时的时区的偏移，如 +08:00
+func fixedTimeZone(loc *time.Location) (string, error) {
+	year := time.Now().Year()
+	_, winter := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+	_, summer := time.Date(year, 7, 1, 0, 0, 0, 0, loc).Zone()
+	if winter != summer {
+		return "", fmt.Errorf("time zone %s has daylight saving time, set -time-zone to a name loaded on the server or an offset for -verify", loc)
+	}
+	sign := "+"
+	if winter < 0 {
+		sign, winter = "-", -winter
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, winter/3600, winter%3600/60), nil
+}
+
+// isApproximate 判断列的值是否不能按字面量精确比较：FLOAT/DOUBLE 输出的十进制值与存储的二进制值不一定相等
+func (t *Table) isApproximate(i int) bool {
+	if tp, _, ok := t.realType(i); ok {
+		return tp == mysql.MYSQL_TYPE_FLOAT || tp == mysql.MYSQL_TYPE_DOUBLE
+	}
+	columnType := t.columnType(i)
+	return strings.HasPrefix(columnType, "float") || strings.HasPrefix(columnType, "double") || strings.HasPrefix(columnType, "real")
+}
+
+// compareConditions 返回与binlog中的数据比较的条件，跳过近似类型的列
+func (t *Table) compareConditions(row []interface{}) []string {
+	var conditions []string
+	for i := range t.Columns {
+		if !t.isApproximate(i) {
+			conditions = append(conditions, t.condition(i, row[i]))
+		}
+	}
+	return conditions
+}
+
+// Run 按回滚的顺序（从新到旧）在当前库中查询，冲突的行及汇总逐行交给 write 输出。
+// 有主键或唯一键时先按键判断行是否存在，再比较其它列判断是否已被修改；没有键时只能判断整行是否存在
+func (v *Verifier) Run(write func(line string) error) error {
+	if v.conn == nil {
+		return fmt.Errorf("-verify needs a database connection")
+	}
+	defer v.conn.Close()
+	seen := make(map[string]bool)
+	total := 0
+	for i := len(v.checks) - 1; i >= 0; i-- {
+		c := v.checks[i]
+		var key string
+		if len(c.t.WhereKeys) != 0 {
+			key = c.t.fullName() + " WHERE " + c.t.whereClause(c.row)
+		}
+		if c.sqlType == "INSERT" {
+			if key != "" {
+				seen[key] = true
+			}
+			continue
+		}
+		total++
+		if key != "" {
+			if seen[key] {
+				v.Covered++
+				continue
+			}
+			seen[key] = true
+		}
+		compare := c.t.compareConditions(c.row)
+		where := key
+		if where == "" {
+			// 没有键时按整行查询，所有列都是近似类型时只能按原值比较
+			if len(compare) == 0 {
+				compare = append(compare, c.t.whereClause(c.row))
+			}
+			where = c.t.fullName() + " WHERE " + strings.Join(compare, " AND ")
+		}
+		found, err := v.rowExists(where)
+		if err != nil {
+			return err
+		}
+		status := "MISSING"
+		if found && key != "" && len(compare) != 0 {
+			if found, err = v.rowExists(where + " AND " + strings.Join(compare, " AND ")); err != nil {
+				return err
+			}
+			if !found {
+				status = "CHANGED"
+			}
+		}
+		if found {
+			v.Matched++
+			continue
+		}
+		if status == "CHANGED" {
+			v.Changed++
+		} else {
+			v.Missing++
+		}
+		if err = write(fmt.Sprintf("%s %s %s:%d %s", status, c.sqlType, c.file, c.pos, where)); err != nil {
+			return err
+		}
+	}
+	return write(fmt.Sprintf("#verify %d rows: %d match, %d changed by later writes, %d missing, %d covered by later changes in range",
+		total, v.Matched, v.Changed, v.Missing, v.Covered))
+}
+
+func (v *Verifier) rowExists(where string) (bool, error) {
+	return db.RowExists(v.conn, fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", where))
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"binlog2sql_go/conf"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestVerifierAddRowsEvent(t *testing.T) {
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 40},
+		ColumnName:  [][]byte{[]byte("id"), []byte("name")},
+		PrimaryKey:  []uint64{0},
+	}
+	event := func(tp replication.EventType, rows ...[]interface{}) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: tp, LogPos: 300, EventSize: 100},
+			Event:  &replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 2, Rows: rows},
+		}
+	}
+	cfg := &conf.Config{}
+	_ = cfg.SqlType.Set("INSERT,UPDATE,DELETE")
+	v := &Verifier{}
+	for _, e := range []*replication.BinlogEvent{
+		event(replication.WRITE_ROWS_EVENTv2, []interface{}{int32(1), "a"}),
+		event(replication.UPDATE_ROWS_EVENTv2, []interface{}{int32(1), "a"}, []interface{}{int32(1), "b"}),
+		event(replication.DELETE_ROWS_EVENTv2, []interface{}{int32(1), "b"}),
+	} {
+		if err := v.AddRowsEvent(e, cfg, "mysql-bin.000001"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// DELETE 回滚为 INSERT，只用于覆盖同一行更早的修改
+	if len(v.checks) != 3 {
+		t.Fatalf("expect 3 checks, got %d", len(v.checks))
+	}
+	if c := v.checks[2]; c.sqlType != "INSERT" || c.row[1] != "b" {
+		t.Errorf("got %+v", c)
+	}
+	if c := v.checks[0]; c.sqlType != "DELETE" || c.row[1] != "a" || c.pos != 200 {
+		t.Errorf("got %+v", c)
+	}
+	// UPDATE 回滚前该行应为修改后的数据
+	if c := v.checks[1]; c.sqlType != "UPDATE" || c.row[1] != "b" || c.t.whereClause(c.row) != "`id`=1" {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestVerifierCompareConditions(t *testing.T) {
+	tm := &replication.TableMapEvent{
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 3,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 8, 40},
+		ColumnName:  [][]byte{[]byte("id"), []byte("price"), []byte("name")},
+		PrimaryKey:  []uint64{0},
+	}
+	tab, err := eventTable(&replication.RowsEvent{Table: tm, TableID: 1, ColumnCount: 3}, &conf.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(tab.compareConditions([]interface{}{int32(1), 0.1, "a"}), " AND ")
+	if want := "`id`=1 AND `name`='a'"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func Test_mysqlTimeZone(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		loc  *time.Location
+		want string
+	}{
+		{time.UTC, "+00:00"},
+		{time.FixedZone("+08:00", 8*3600), "+08:00"},
+		{time.FixedZone("-03:30", -3*3600-1800), "-03:30"},
+		{shanghai, "Asia/Shanghai"},
+	}
+	for _, tt := range tests {
+		if got, err := mysqlTimeZone(tt.loc); err != nil || got != tt.want {
+			t.Errorf("mysqlTimeZone(%v) = %s %v, want %s", tt.loc, got, err, tt.want)
+		}
+	}
+	if got, err := fixedTimeZone(shanghai); err != nil || got != "+08:00" {
+		t.Errorf("fixedTimeZone(Asia/Shanghai) = %s %v", got, err)
+	}
+	if _, err := fixedTimeZone(newYork); err == nil {
+		t.Error("expect error for a time zone with daylight saving time")
+	}
+}
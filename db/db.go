@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
@@ -9,19 +10,21 @@ import (
 var Conn *sql.DB
 
 func InitDb(host, user, password string, port uint) error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/information_schema?charset=utf8", user, password, host, port)
-	var err error
-	Conn, err = sql.Open("mysql", dsn)
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/information_schema?charset=utf8mb4,utf8", user, password, host, port)
+	conn, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return err
 	}
-	Conn.SetMaxOpenConns(4)
-	Conn.SetMaxIdleConns(2)
-	Conn.SetConnMaxLifetime(1000)
-	Conn.SetConnMaxIdleTime(600)
-	if err := Conn.Ping(); err != nil {
+	conn.SetMaxOpenConns(4)
+	conn.SetMaxIdleConns(2)
+	conn.SetConnMaxLifetime(1000)
+	conn.SetConnMaxIdleTime(600)
+	if err := conn.Ping(); err != nil {
+		conn.Close()
 		return err
 	}
+	// 连接成功后才设置 Conn，离线解析时通过 Conn 是否为nil判断能否查询表结构
+	Conn = conn
 	return nil
 }
 
@@ -30,7 +33,7 @@ func GetColumns(schema, table string) (columns []string, err error) {
 		return nil, err
 	}
 	var rows *sql.Rows
-	rows, err = Conn.Query(fmt.Sprintf(`select column_name from information_schema.columns where table_schema="%s" and table_name = "%s" order by ORDINAL_POSITION`, schema, table))
+	rows, err = Conn.Query(`select column_name from information_schema.columns where table_schema=? and table_name=? order by ORDINAL_POSITION`, schema, table)
 	if err != nil {
 		return
 	}
@@ -47,7 +50,7 @@ func GetPk(schema, table string) (pk []string, err error) {
 		return nil, err
 	}
 	var rows *sql.Rows
-	rows, err = Conn.Query(fmt.Sprintf(`select column_name from information_schema.columns where table_schema="%s" and table_name = "%s" and column_key='PRI' order by ORDINAL_POSITION`, schema, table))
+	rows, err = Conn.Query(`select column_name from information_schema.columns where table_schema=? and table_name=? and column_key='PRI' order by ORDINAL_POSITION`, schema, table)
 	if err != nil {
 		return
 	}
@@ -59,6 +62,76 @@ func GetPk(schema, table string) (pk []string, err error) {
 	return
 }
 
+// GetColumnTypes 返回按列顺序排列的 column_type，如 int(10) unsigned、varbinary(16)、enum('a','b')
+func GetColumnTypes(schema, table string) (types []string, err error) {
+	if err := Conn.Ping(); err != nil {
+		return nil, err
+	}
+	var rows *sql.Rows
+	rows, err = Conn.Query(`select column_type from information_schema.columns where table_schema=? and table_name=? order by ORDINAL_POSITION`, schema, table)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var columnType string
+		_ = rows.Scan(&columnType)
+		types = append(types, columnType)
+	}
+	return
+}
+
+// GetAutoIncrement 返回 AUTO_INCREMENT 列
+func GetAutoIncrement(schema, table string) (columns []string, err error) {
+	if err := Conn.Ping(); err != nil {
+		return nil, err
+	}
+	var rows *sql.Rows
+	rows, err = Conn.Query(`select column_name from information_schema.columns where table_schema=? and table_name=? and extra like '%auto_increment%' order by ORDINAL_POSITION`, schema, table)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var column string
+		_ = rows.Scan(&column)
+		columns = append(columns, column)
+	}
+	return
+}
+
+// GetUniqueKey 返回第一个所有列都是 NOT NULL 的唯一索引（不含主键）的列
+func GetUniqueKey(schema, table string) (uk []string, err error) {
+	if err := Conn.Ping(); err != nil {
+		return nil, err
+	}
+	var rows *sql.Rows
+	rows, err = Conn.Query(`select s.index_name, s.column_name, c.is_nullable from information_schema.statistics s
+join information_schema.columns c on c.table_schema=s.table_schema and c.table_name=s.table_name and c.column_name=s.column_name
+where s.table_schema=? and s.table_name=? and s.non_unique=0 and s.index_name<>'PRIMARY'
+order by s.index_name, s.seq_in_index`, schema, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var current string
+	var nullable bool
+	for rows.Next() {
+		var index, column, isNullable string
+		_ = rows.Scan(&index, &column, &isNullable)
+		if index != current {
+			if current != "" && !nullable {
+				return
+			}
+			current, nullable, uk = index, false, nil
+		}
+		nullable = nullable || isNullable == "YES"
+		uk = append(uk, column)
+	}
+	if nullable {
+		uk = nil
+	}
+	return
+}
+
 type Variables struct {
 	ServerId                     int
 	LogBin                       bool
@@ -76,3 +149,32 @@ func GetVariables() (v Variables, err error) {
 	}
 	return
 }
+
+// GetServerTime 返回服务器当前的unix时间戳，与binlog中事件的时间戳可以直接比较
+func GetServerTime() (ts uint32, err error) {
+	err = Conn.QueryRow("select unix_timestamp();").Scan(&ts)
+	return
+}
+
+// NewSession 返回一个独占的连接并设置会话的 time_zone，TIMESTAMP列的值按该时区比较
+func NewSession(timeZone string) (*sql.Conn, error) {
+	conn, err := Conn.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.ExecContext(context.Background(), "SET time_zone = ?", timeZone); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// RowExists 在 conn 上执行只读的 SELECT 查询，返回是否有结果行
+func RowExists(conn *sql.Conn, query string) (bool, error) {
+	var one int
+	err := conn.QueryRowContext(context.Background(), query).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -4,14 +4,20 @@ import (
 	"binlog2sql_go/conf"
 	"binlog2sql_go/core"
 	"binlog2sql_go/db"
+	"binlog2sql_go/sink"
 	"binlog2sql_go/utils"
 	"bytes"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
@@ -22,76 +28,191 @@ import (
 var cfg *conf.Config
 var pos []uint32
 var currentBinlogFile string
+var flashbackBuf *core.FlashbackBuffer
+
+// currentGtid 为当前事务的GTID，skipTrx 表示当前事务被GTID条件过滤，stopAfterTrx 表示当前事务结束后停止解析
+var currentGtid string
+var skipTrx, stopAfterTrx bool
+
+// trxBoundary 跟踪事务边界，以事务为单位处理时跳过开始在解析范围之前的事务
+var trxBoundary core.TrxBoundary
+
+// stopped 为true表示已到达停止条件，onJob 返回的 errStop 不是真正的错误
+var stopped bool
+var errStop = errors.New("stop parsing")
+
+// verifier 为 -verify 模式下收集的待校验的行
+var verifier = &core.Verifier{}
+
+// conflicts 为 -detect-conflicts 时回滚范围内的行，pastStop 表示已读到停止位置之后，只检查是否修改了这些行
+var conflicts *core.ConflictDetector
+var pastStop bool
+
+// squasher 为 -squash 时合并的净变更
+var squasher *core.Squasher
+
+// out 为SQL及数据变更的输出，提示信息和错误输出到标准错误
+var out sink.Sink
+
+// lastFlush 为上次刷新 out 的时间
+var lastFlush time.Time
+
+// sigCtx 在收到 Ctrl-C 或 SIGTERM 后被取消，此时停止解析并正常关闭输出
+var sigCtx context.Context
+
+// pipe 为 -threads 大于1时并发处理事件的流水线，事件按binlog顺序交给 onJob 处理
+var pipe *core.Pipeline
+
+// trx 为 -transaction 或事务级过滤条件下正在收集的事务，不在事务中时为nil
+var trx *core.Transaction
 
 func main() {
 	var binlogList []string
 
 	cfg = conf.NewConfig()
 	conf.ParseConfig(cfg)
-	if err := db.InitDb(cfg.Host, cfg.User, cfg.Password, cfg.Port); err != nil {
-		fmt.Println(err)
+	if err := core.InitTableFilter(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -databases/-tables filter error: %v\n", err)
 		return
 	}
-	v, err := db.GetVariables()
-	if err != nil {
-		fmt.Println(err)
-		return
+	if cfg.SchemaFile != "" {
+		if err := core.LoadSchemaFile(cfg.SchemaFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
 	}
-	if v.ServerId == 0 {
-		fmt.Printf("Error: missing server_id in %s:%v\n", cfg.Host, cfg.Port)
-		return
+	if cfg.ConnectDb {
+		if err := db.InitDb(cfg.Host, cfg.User, cfg.Password, cfg.Port); err != nil {
+			if !cfg.Local {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			// 离线解析时数据库不可用不影响使用binlog中的元数据或 -schema-file
+			fmt.Fprintf(os.Stderr, "Warning: %v, parse without database connection\n", err)
+		}
 	}
-	if !v.LogBin {
-		fmt.Printf("Error: binlog is disabled in %s:%v\n", cfg.Host, cfg.Port)
-		return
+	if cfg.Verify {
+		// 没有数据库连接时解析完才报错会白白读完所有binlog
+		if err := verifier.Connect(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
 	}
-	if strings.ToUpper(v.BinlogFormat) != "ROW" {
-		fmt.Printf("Error: binlog format is not 'ROW' in %s:%v\n", cfg.Host, cfg.Port)
+	if !cfg.Local {
+		if err := checkServer(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+	if cfg.Flashback {
+		flashbackBuf = core.NewFlashbackBuffer(0)
+		defer flashbackBuf.Close()
+	}
+	if cfg.DetectConflicts {
+		conflicts = core.NewConflictDetector()
+	}
+	if cfg.Squash {
+		squasher = core.NewSquasher(0)
+		defer squasher.Close()
+	}
+	var err error
+	if out, err = sink.New(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	if strings.ToUpper(v.BinlogRowImage) != "FULL" {
-		fmt.Printf("Error: binlog format is not 'FULL' in %s:%v\n", cfg.Host, cfg.Port)
+	defer func() {
+		if err := out.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	if a, ok := out.(*sink.ApplySink); ok && a.Checkpoint() != nil && !resumeFromCheckpoint(a.Checkpoint()) {
+		fmt.Fprintf(os.Stderr, "#checkpoint %s:%d is out of range, nothing to apply\n", a.Checkpoint().File, a.Checkpoint().Pos)
 		return
 	}
+	var stopSignal context.CancelFunc
+	sigCtx, stopSignal = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignal()
+	if cfg.Threads > 1 {
+		pipe = core.NewPipeline(int(cfg.Threads), cfg, onJob)
+	}
 	if cfg.Local {
-		BinlogLocalReader(cfg.LocalFile)
-	} else {
-		var _ignore string
-		rows, err := db.Conn.Query("show binary logs;")
+		files, err := utils.ResolveBinlogFiles(cfg.LocalFiles)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			return
 		}
-		var ok bool
-		startId, _ := strconv.Atoi(strings.Split(cfg.StartFile, ".")[1])
-		stopId, _ := strconv.Atoi(strings.Split(cfg.StopFile, ".")[1])
-		for rows.Next() {
-			var logName string
-			err := rows.Scan(&logName, &_ignore)
+		if cfg.StartFile == "" && !cfg.StartDatetime.IsZero() {
+			file, err := utils.SearchBinlogFile(files, cfg.StartDatetime, localFirstEventTime)
 			if err != nil {
-				fmt.Println(err)
+				fmt.Fprintln(os.Stderr, err)
 				return
 			}
+			cfg.StartFile = filepath.Base(file)
+			fmt.Fprintf(os.Stderr, "#start from %s found by -start-datetime\n", cfg.StartFile)
+		}
+		stopFile := cfg.StopFile
+		if conflicts != nil {
+			// 需要继续扫描停止位置之后的binlog
+			stopFile = ""
+		}
+		if files = utils.FilterBinlogFiles(files, cfg.StartFile, stopFile); len(files) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: no binlog file to parse in -local-file")
+			return
+		}
+		// 未指定 -start-file/-stop-file 时，-start-position/-stop-position 作用于第一个/最后一个文件
+		if cfg.StartFile == "" {
+			cfg.StartFile = filepath.Base(files[0])
+		}
+		if cfg.StopFile == "" {
+			cfg.StopFile = filepath.Base(files[len(files)-1])
+		}
+		for _, file := range files {
+			if err := BinlogLocalReader(file); err != nil || stopped {
+				if !stopped {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				break
+			}
+		}
+	} else {
+		logs, err := binaryLogs()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if cfg.StartFile == "" && cfg.StartGtid == nil && !cfg.StartDatetime.IsZero() {
+			if cfg.StartFile, err = utils.SearchBinlogFile(logs, cfg.StartDatetime, onlineFirstEventTime); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "#start from %s found by -start-datetime\n", cfg.StartFile)
+		}
+		// 通过 -start-gtid 开始解析时不限制起始文件
+		ok := cfg.StartFile == ""
+		_, startId, _ := utils.BinlogSeq(cfg.StartFile)
+		_, stopId, hasStop := utils.BinlogSeq(cfg.StopFile)
+		hasStop = hasStop && conflicts == nil
+		for _, logName := range logs {
 			if cfg.StartFile == logName {
 				ok = true
 			}
-			logId, _ := strconv.Atoi(strings.Split(logName, ".")[1])
-			if ok && startId <= logId && logId <= stopId {
+			_, logId, _ := utils.BinlogSeq(logName)
+			if ok && startId <= logId && (!hasStop || logId <= stopId) {
 				binlogList = append(binlogList, logName)
 			}
 		}
 		currentBinlogFile = cfg.StartFile
 		if !ok {
-			fmt.Printf("Error: -start-file %s not in mysql server", cfg.StartFile)
+			fmt.Fprintf(os.Stderr, "Error: -start-file %s not in mysql server\n", cfg.StartFile)
 			return
 		}
 		streamer, err := BinlogStreamReader(cfg)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			return
 		}
 		for {
-			ctx := context.Background()
+			ctx := sigCtx
 			var timeout context.CancelFunc
 			var e *replication.BinlogEvent
 			var err error
@@ -103,7 +224,9 @@ func main() {
 				e, err = streamer.GetEvent(ctx)
 			}
 			if err != nil {
-				fmt.Println(err)
+				if sigCtx.Err() == nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
 				break
 			}
 			if e.Header.EventType == replication.ROTATE_EVENT {
@@ -111,15 +234,84 @@ func main() {
 				if !cfg.StopNever && !utils.Contains(binlogList, string(rotateEvent.NextLogName)) {
 					break
 				}
+				// 切换文件前之前的事件要按原来的文件名处理完
+				if pipe != nil {
+					if err = pipe.Wait(); err != nil {
+						if !stopped {
+							fmt.Fprintln(os.Stderr, err)
+						}
+						break
+					}
+				}
 				currentBinlogFile = string(rotateEvent.NextLogName)
-				fmt.Printf("#Rotate to %s\n", currentBinlogFile)
+				fmt.Fprintf(os.Stderr, "#Rotate to %s\n", currentBinlogFile)
 			}
-			if err = onEvent(e); err != nil {
-				fmt.Println(err)
+			if err = handleEvent(e); err != nil {
+				if !stopped {
+					fmt.Fprintln(os.Stderr, err)
+				}
 				break
 			}
 		}
 	}
+	if pipe != nil {
+		if err := pipe.Close(); err != nil && !stopped {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if sigCtx.Err() != nil && len(pos) != 0 {
+		fmt.Fprintf(os.Stderr, "#interrupted at %s:%d\n", currentBinlogFile, pos[len(pos)-1])
+	}
+	if trx != nil && trx.Len() != 0 {
+		// 未读到结束的事务不输出，避免回放时只执行了事务的一部分
+		fmt.Fprintf(os.Stderr, "#discard incomplete transaction started at %s:%d\n", currentBinlogFile, trx.StartPos)
+	}
+	if cfg.Verify {
+		if err := verifier.Run(func(line string) error {
+			return out.WriteChange(&sink.Change{SQL: line})
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	} else if cfg.Flashback {
+		if err := flashbackBuf.Each(func(entry core.FlashbackEntry) error {
+			return out.WriteChange(&sink.Change{SQL: entry.SQL, File: entry.File, Pos: entry.Pos, Gtid: entry.Gtid})
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if squasher != nil {
+		if err := squasher.Each(cfg, func(sql string) error {
+			return out.WriteChange(&sink.Change{SQL: sql})
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if conflicts != nil {
+		if err := conflicts.Report(os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// checkServer 检查在线解析的MySQL实例是否满足条件
+func checkServer() error {
+	v, err := db.GetVariables()
+	if err != nil {
+		return err
+	}
+	if v.ServerId == 0 {
+		return fmt.Errorf("Error: missing server_id in %s:%v", cfg.Host, cfg.Port)
+	}
+	if !v.LogBin {
+		return fmt.Errorf("Error: binlog is disabled in %s:%v", cfg.Host, cfg.Port)
+	}
+	if strings.ToUpper(v.BinlogFormat) != "ROW" {
+		return fmt.Errorf("Error: binlog format is not 'ROW' in %s:%v", cfg.Host, cfg.Port)
+	}
+	if strings.ToUpper(v.BinlogRowImage) != "FULL" {
+		return fmt.Errorf("Error: binlog format is not 'FULL' in %s:%v", cfg.Host, cfg.Port)
+	}
+	return nil
 }
 
 // type binlogEvent struct {
@@ -127,20 +319,123 @@ func main() {
 // 	e            *replication.BinlogEvent
 // }
 
-func onEvent(e *replication.BinlogEvent) error {
+// handleEvent 为解析binlog的回调，-threads 大于1时交给流水线并发处理
+func handleEvent(e *replication.BinlogEvent) error {
+	if pipe != nil {
+		return pipe.Submit(e)
+	}
+	return onJob(&core.Job{Event: e})
+}
+
+// onJob 按binlog顺序处理事件，行事件的SQL可能已由流水线预先生成
+func onJob(j *core.Job) error {
+	e := j.Event
+	if sigCtx.Err() != nil {
+		stopped = true
+		return errStop
+	}
 	if pos = append(pos, e.Header.LogPos); len(pos) > 2 {
 		pos = pos[len(pos)-2:]
 	}
 
 	lastEventPos := pos[0]
+	beginTrx := trxBoundary.Add(cfg, currentBinlogFile, e)
 
+	if trx != nil {
+		trx.AddEvent(e)
+	}
+	// 读到停止位置或 -stop-datetime 之后的事件时停止解析，正在收集的事务中之后的事件由下面的过滤条件跳过，事务结束后再停止
+	if !pastStop && afterStop(e) && (trx == nil || conflicts != nil) {
+		if err := reachStop(); err != nil {
+			return err
+		}
+	}
+	switch e.Header.EventType {
+	case replication.GTID_EVENT, replication.ANONYMOUS_GTID_EVENT, replication.MARIADB_GTID_EVENT:
+		if stopAfterTrx {
+			if err := reachStop(); err != nil {
+				return err
+			}
+		}
+		if err := core.CheckGtidFlavor(cfg, e); err != nil {
+			return err
+		}
+		currentGtid, _ = core.GtidOfEvent(e)
+		skipTrx = core.SkipGtid(cfg, currentGtid)
+		stopAfterTrx = core.IsStopGtid(cfg, currentGtid)
+		// MariaDB 的事务以GTID事件开始，没有 BEGIN 事件
+		if beginTrx && cfg.WholeTransaction() {
+			trx = &core.Transaction{Gtid: currentGtid, StartPos: lastEventPos, Timestamp: e.Header.Timestamp}
+			trx.AddEvent(e)
+		}
+		return nil
+	case replication.XID_EVENT:
+		if trx != nil {
+			trx.Xid = e.Event.(*replication.XIDEvent).XID
+			trx.EndPos = e.Header.LogPos
+			if err := commitTrx(); err != nil {
+				return err
+			}
+		}
+		if err := flushOutput(); err != nil {
+			return err
+		}
+		if stopAfterTrx {
+			return reachStop()
+		}
+		return nil
+	case replication.QUERY_EVENT:
+		// DDL不论是否在解析范围内都要应用到表结构跟踪中
+		if err := core.ApplyQueryEvent(e); err != nil {
+			fmt.Fprintf(os.Stderr, "#warning schema tracking skipped query at %s:%d: %v\n", currentBinlogFile, e.Header.LogPos, err)
+		}
+		switch string(e.Event.(*replication.QueryEvent).Query) {
+		case "BEGIN":
+			// JSON输出需要事务结束时的XID，-apply-to 以事务为单位执行。BEGIN 在解析范围之前时不收集该事务
+			if beginTrx && cfg.WholeTransaction() {
+				trx = &core.Transaction{Gtid: currentGtid, StartPos: lastEventPos, Timestamp: e.Header.Timestamp}
+				trx.AddEvent(e)
+			}
+			return nil
+		case "COMMIT":
+			// 非事务引擎的表以 COMMIT 语句而不是XID事件结束
+			if trx != nil {
+				trx.EndPos = e.Header.LogPos
+				if err := commitTrx(); err != nil {
+					return err
+				}
+			}
+			if err := flushOutput(); err != nil {
+				return err
+			}
+			if stopAfterTrx {
+				return reachStop()
+			}
+			return nil
+		}
+	}
+	if pastStop {
+		if isDMLEvent(e) {
+			if err := conflicts.CheckRowsEvent(e, cfg, currentBinlogFile); err != nil {
+				return rowsEventError(err)
+			}
+		}
+		return nil
+	}
+	if skipTrx {
+		return nil
+	}
+	// 以事务为单位处理时不输出不完整的事务，否则按事件的位置过滤
+	if isDMLEvent(e) && cfg.WholeTransaction() && trxBoundary.Partial() {
+		return nil
+	}
 	if !isDMLEvent(e) && e.Header.EventType != replication.QUERY_EVENT {
 		return nil
 	}
 	if cfg.OnlyDML && !isDMLEvent(e) {
 		return nil
 	}
-	eventTime := time.Unix(int64(e.Header.Timestamp), 0)
+	eventTime := cfg.EventTime(e.Header.Timestamp)
 	if !cfg.StartDatetime.IsZero() && eventTime.Before(cfg.StartDatetime) {
 		return nil
 	}
@@ -153,64 +448,303 @@ func onEvent(e *replication.BinlogEvent) error {
 	if currentBinlogFile == cfg.StartFile && e.Header.LogPos < uint32(cfg.StartPosition) {
 		return nil
 	}
+	if conflicts != nil && isDMLEvent(e) {
+		if err := conflicts.AddRowsEvent(e, cfg); err != nil {
+			if err = rowsEventError(err); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.Verify {
+		if isDMLEvent(e) {
+			if err := verifier.AddRowsEvent(e, cfg, currentBinlogFile); err != nil {
+				return rowsEventError(err)
+			}
+		}
+		return nil
+	}
+	if squasher != nil {
+		// 合并后的修改在最后输出，DDL无法与之保持顺序，不输出
+		if !isDMLEvent(e) {
+			return nil
+		}
+		handled, err := squasher.AddRowsEvent(e, cfg, currentBinlogFile)
+		if err != nil {
+			return rowsEventError(err)
+		}
+		if handled {
+			return nil
+		}
+	}
+	if cfg.OutputFormat != "sql" {
+		if !isDMLEvent(e) {
+			return nil
+		}
+		changes, err := j.RowChanges(cfg)
+		if err != nil {
+			return rowsEventError(err)
+		}
+		for _, c := range changes {
+			c.File, c.Gtid = currentBinlogFile, currentGtid
+		}
+		if trx != nil {
+			trx.AddChange(changes...)
+			return nil
+		}
+		return outputChanges(changes)
+	}
 	var err error
 	var sql string
 	if e.Header.EventType == replication.QUERY_EVENT && !cfg.Flashback {
 		sql, err = core.ConcatSqlFromQueryEvent(e, cfg)
 	} else if isDMLEvent(e) {
-		sql, err = core.ConcatSqlFromRowsEvent(e, cfg)
+		sql, err = j.Sql(cfg)
 	}
 	if err != nil {
-		return nil
+		return rowsEventError(err)
 	}
 	if sql != "" {
-		sql = fmt.Sprintf("%s #start %v end %v time %v", sql, lastEventPos, e.Header.LogPos, time.Unix(int64(e.Header.Timestamp), 0).Format("2006-01-02 15:04:05"))
-		fmt.Println(sql)
+		sql = fmt.Sprintf("%s #start %v end %v time %v", sql, lastEventPos, e.Header.LogPos, cfg.EventTime(e.Header.Timestamp).Format("2006-01-02 15:04:05"))
+		if currentGtid != "" {
+			sql = fmt.Sprintf("%s gtid %s", sql, currentGtid)
+		}
+		if trx != nil {
+			trx.Add(sql)
+			return nil
+		}
+		if e.Header.EventType == replication.QUERY_EVENT {
+			if err = out.WriteDDL(&sink.Change{SQL: sql, File: currentBinlogFile, Pos: e.Header.LogPos, Gtid: currentGtid}); err != nil {
+				return err
+			}
+			return flushOutput()
+		}
+		return output(sql, lastEventPos, e.Header.LogPos)
+	}
+
+	return nil
+}
+
+// rowsEventError 处理生成SQL时的错误：表结构不一致时之后该表的行都无法正确解析，停止解析；
+// 其他错误只输出到stderr，跳过该事件
+func rowsEventError(err error) error {
+	var mismatch *core.SchemaMismatchError
+	if errors.As(err, &mismatch) {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return nil
+}
+
+// reachStop 到达停止条件：-detect-conflicts 时继续扫描之后的binlog，否则停止解析
+func reachStop() error {
+	stopAfterTrx = false
+	if conflicts != nil {
+		pastStop = true
+		return nil
+	}
+	stopped = true
+	return errStop
+}
+
+// afterStop 判断事件是否在 -stop-file/-stop-position/-stop-datetime 之后
+func afterStop(e *replication.BinlogEvent) bool {
+	if !cfg.StopDatetime.IsZero() && cfg.EventTime(e.Header.Timestamp).After(cfg.StopDatetime) {
+		return true
 	}
+	// -stop-never 时 -stop-file 默认为 -start-file，不作为停止条件
+	if cfg.StopNever {
+		return false
+	}
+	_, stopSeq, ok := utils.BinlogSeq(cfg.StopFile)
+	if !ok {
+		return false
+	}
+	if _, seq, _ := utils.BinlogSeq(currentBinlogFile); seq > stopSeq {
+		return true
+	}
+	return currentBinlogFile == cfg.StopFile && cfg.StopPosition != 0 && e.Header.LogPos > uint32(cfg.StopPosition)
+}
 
+// flushOutput 在事务结束或输出DDL后刷新输出的缓冲，每秒最多刷新一次；
+// -stop-never 时每次都刷新，使持续解析的输出可以被实时读取
+func flushOutput() error {
+	if !cfg.StopNever && time.Since(lastFlush) < time.Second {
+		return nil
+	}
+	lastFlush = time.Now()
+	return out.Flush()
+}
+
+// commitTrx 输出收集到的事务，事务中没有生成SQL或不满足事务级过滤条件时不输出
+func commitTrx() error {
+	t := trx
+	trx = nil
+	if t.Len() == 0 || !t.Match(cfg) {
+		return nil
+	}
+	if cfg.OutputFormat != "sql" {
+		return outputChanges(t.Changes())
+	}
+	if cfg.ApplyTo != "" {
+		// 在目标库执行时由 -apply-to 开启事务，不能包含 BEGIN/COMMIT
+		return output(t.Body(cfg.Flashback), t.StartPos, t.EndPos)
+	}
+	if cfg.Transaction {
+		return output(t.String(cfg), t.StartPos, t.EndPos)
+	}
+	for _, sql := range t.Stmts() {
+		if err := output(sql, t.StartPos, t.EndPos); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func BinlogLocalReader(file string) {
+// outputChanges 输出行变更，格式由 -output-format 决定
+func outputChanges(changes []*core.RowChange) error {
+	for _, c := range changes {
+		if err := out.WriteChange(&sink.Change{Row: c}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// output 输出SQL，start、end 为SQL在binlog中的起止位置，回滚时记录起始位置，否则记录结束位置
+func output(sql string, start, end uint32) error {
+	if cfg.Flashback {
+		return flashbackBuf.AppendEntry(core.FlashbackEntry{File: currentBinlogFile, Pos: start, Gtid: currentGtid, SQL: sql})
+	}
+	return out.WriteChange(&sink.Change{SQL: sql, File: currentBinlogFile, Pos: end, Gtid: currentGtid})
+}
+
+// resumeFromCheckpoint 从 -apply-to 的检查点继续：正向执行从检查点之后开始，回滚只处理检查点之前的部分。
+// 检查点已超出解析范围时返回false
+func resumeFromCheckpoint(cp *sink.Checkpoint) bool {
+	_, cpSeq, _ := utils.BinlogSeq(cp.File)
+	if cfg.Flashback {
+		if _, startSeq, ok := utils.BinlogSeq(cfg.StartFile); ok && (cpSeq < startSeq || cp.File == cfg.StartFile && cp.Pos <= uint32(cfg.StartPosition)) {
+			return false
+		}
+		cfg.StopFile, cfg.StopPosition = cp.File, uint(cp.Pos)
+	} else {
+		if cfg.StopNever {
+			cfg.StopFile = ""
+		} else if _, stopSeq, ok := utils.BinlogSeq(cfg.StopFile); ok && (cpSeq > stopSeq || cp.File == cfg.StopFile && cfg.StopPosition != 0 && cp.Pos >= uint32(cfg.StopPosition)) {
+			return false
+		}
+		cfg.StartFile, cfg.StartPosition, cfg.StartGtid = cp.File, uint(cp.Pos), nil
+	}
+	fmt.Fprintf(os.Stderr, "#resume from checkpoint %s:%d\n", cp.File, cp.Pos)
+	return true
+}
+
+func BinlogLocalReader(file string) error {
 	f, err := os.Open(file)
 	if err != nil {
-		fmt.Println("error: ", err)
-		return
-	}
-	if f != nil {
-		defer f.Close()
+		return err
 	}
+	defer f.Close()
 	binlogHeader := int64(4)
 	buf := make([]byte, binlogHeader)
-	_, err = f.Read(buf)
-	if err != nil {
-		fmt.Println(err)
-		return
+	if _, err = f.Read(buf); err != nil {
+		return err
 	}
 	if !bytes.Equal(buf, replication.BinLogFileHeader) {
-		fmt.Printf("file header is not match,file may be damaged \n")
-		return
+		return fmt.Errorf("file header is not match,file %s may be damaged", file)
 	}
-	if _, err := f.Seek(binlogHeader, os.SEEK_SET); err != nil {
-		fmt.Println(err.Error())
-		return
+	if _, err := f.Seek(binlogHeader, io.SeekStart); err != nil {
+		return err
 	}
+	currentBinlogFile = filepath.Base(file)
+	pos = []uint32{uint32(binlogHeader)}
 	binlogParser := replication.NewBinlogParser()
-	err = binlogParser.ParseReader(f, onEvent)
+	binlogParser.SetUseDecimal(true)
+	binlogParser.SetTimestampStringLocation(cfg.Location())
+	if pipe == nil {
+		return binlogParser.ParseReader(f, handleEvent)
+	}
+	// 行数据由流水线的工作goroutine解码，文件读完后等待处理完再切换到下一个文件
+	binlogParser.SetRowsEventDecodeFunc(pipe.DecodeRowsHeader)
+	if err := binlogParser.ParseReader(f, handleEvent); err != nil {
+		return err
+	}
+	return pipe.Wait()
+}
+
+// binaryLogs 返回 SHOW BINARY LOGS 中的binlog文件
+func binaryLogs() (logs []string, err error) {
+	rows, err := db.Conn.Query("show binary logs;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, err
+	}
+	// MySQL 8.0 比 5.7 多了 Encrypted 列
+	dest := make([]interface{}, len(cols))
+	var logName string
+	var ignore sql.RawBytes
+	dest[0] = &logName
+	for i := 1; i < len(dest); i++ {
+		dest[i] = &ignore
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		logs = append(logs, logName)
+	}
+	return logs, rows.Err()
+}
+
+// localFirstEventTime 返回本地binlog文件第一个事件的时间
+func localFirstEventTime(file string) (t time.Time, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	buf := make([]byte, len(replication.BinLogFileHeader)+replication.EventHeaderSize)
+	if _, err = io.ReadFull(f, buf); err != nil {
+		return t, fmt.Errorf("read first event of %s: %v", file, err)
+	}
+	if !bytes.Equal(buf[:len(replication.BinLogFileHeader)], replication.BinLogFileHeader) {
+		return t, fmt.Errorf("file header is not match,file %s may be damaged", file)
+	}
+	h := &replication.EventHeader{}
+	if err = h.Decode(buf[len(replication.BinLogFileHeader):]); err != nil {
 		return
 	}
+	return time.Unix(int64(h.Timestamp), 0), nil
 }
 
-func BinlogStreamReader(conf *conf.Config) (*replication.BinlogStreamer, error) {
-	rand.Seed(time.Now().UnixNano())
-	handler, err := log.NewFileHandler("binlog2sql_go.log", os.O_CREATE|os.O_WRONLY)
+// onlineFirstEventTime 返回在线binlog文件第一个事件的时间，跳过时间为0的虚拟 RotateEvent
+func onlineFirstEventTime(file string) (time.Time, error) {
+	handler, _ := log.NewNullHandler()
+	syncer := replication.NewBinlogSyncer(binlogSyncerConfig(cfg, log.NewDefault(handler)))
+	defer syncer.Close()
+	streamer, err := syncer.StartSync(mysql.Position{Name: file, Pos: 4})
 	if err != nil {
-		return nil, err
+		return time.Time{}, err
+	}
+	for {
+		ctx, cancel := context.WithTimeout(sigCtx, time.Second*3)
+		e, err := streamer.GetEvent(ctx)
+		cancel()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("read first event of %s: %v", file, err)
+		}
+		if e.Header.Timestamp != 0 {
+			return time.Unix(int64(e.Header.Timestamp), 0), nil
+		}
 	}
-	logger := log.NewDefault(handler)
-	syncConf := replication.BinlogSyncerConfig{
+}
+
+func binlogSyncerConfig(conf *conf.Config, logger *log.Logger) replication.BinlogSyncerConfig {
+	return replication.BinlogSyncerConfig{
 		ServerID:        uint32(rand.Intn(2<<31) - 1),
 		Host:            conf.Host,
 		Port:            uint16(conf.Port),
@@ -218,10 +752,27 @@ func BinlogStreamReader(conf *conf.Config) (*replication.BinlogStreamer, error)
 		Password:        conf.Password,
 		Charset:         "utf8",
 		SemiSyncEnabled: false,
-		UseDecimal:      false,
+		UseDecimal:      true,
 		Logger:          logger,
+		// TIMESTAMP列的值按 -time-zone 输出
+		TimestampStringLocation: conf.Location(),
+	}
+}
+
+func BinlogStreamReader(conf *conf.Config) (*replication.BinlogStreamer, error) {
+	rand.Seed(time.Now().UnixNano())
+	handler, err := log.NewFileHandler("binlog2sql_go.log", os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return nil, err
+	}
+	syncConf := binlogSyncerConfig(conf, log.NewDefault(handler))
+	if pipe != nil {
+		syncConf.RowsEventDecodeFunc = pipe.DecodeRowsHeader
 	}
 	replSyncer := replication.NewBinlogSyncer(syncConf)
+	if conf.StartGtid != nil {
+		return replSyncer.StartSyncGTID(conf.StartGtid)
+	}
 	position := mysql.Position{
 		Name: conf.StartFile,
 		Pos:  uint32(conf.StartPosition),
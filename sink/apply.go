@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ApplySink 在目标MySQL上直接执行生成的SQL，每条变更（一个事务）在一个数据库事务中执行，
+// 并保存已执行到的binlog位置，中断后可以从检查点继续
+type ApplySink struct {
+	db        *sql.DB
+	store     checkpointStore
+	flashback bool
+	last      *Checkpoint
+}
+
+// NewApplySink 连接 dsn（如 user:password@tcp(127.0.0.1:3306)/）指定的目标库，
+// checkpointTable 不为空时检查点保存在目标库的表中，否则保存在 checkpointFile 中
+func NewApplySink(dsn string, flashback bool, checkpointFile, checkpointTable string) (*ApplySink, error) {
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("-apply-to: %v", err)
+	}
+	// 一个事务中的多条SQL一次执行
+	c.MultiStatements = true
+	db, err := sql.Open("mysql", c.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	// DDL中的USE语句依赖同一个连接
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &ApplySink{db: db, flashback: flashback}
+	if checkpointTable != "" {
+		s.store, err = newTableCheckpoint(db, checkpointTable)
+	} else {
+		if checkpointFile == "" {
+			checkpointFile = defaultCheckpointFile
+		}
+		s.store = &fileCheckpoint{name: checkpointFile}
+	}
+	if err == nil {
+		s.last, err = s.store.Load()
+	}
+	if err == nil && s.last != nil && s.last.Flashback != flashback {
+		err = fmt.Errorf("checkpoint %s:%d was saved by a run with flashback=%v", s.last.File, s.last.Pos, s.last.Flashback)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Checkpoint 返回上次执行到的位置，没有检查点时返回nil
+func (s *ApplySink) Checkpoint() *Checkpoint {
+	return s.last
+}
+
+func (s *ApplySink) WriteChange(c *Change) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	cp := &Checkpoint{File: c.File, Pos: c.Pos, Gtid: c.Gtid, Flashback: s.flashback}
+	if _, err = tx.Exec(c.SQL); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("apply %s:%d: %v", c.File, c.Pos, err)
+	}
+	if s.store.Transactional() {
+		err = s.store.Save(tx, cp)
+	} else {
+		err = s.store.Save(nil, s.pending(cp))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	return s.commitCheckpoint(cp)
+}
+
+// WriteDDL DDL会隐式提交，单独执行后再保存检查点
+func (s *ApplySink) WriteDDL(c *Change) error {
+	cp := &Checkpoint{File: c.File, Pos: c.Pos, Gtid: c.Gtid, Flashback: s.flashback}
+	if !s.store.Transactional() {
+		if err := s.store.Save(nil, s.pending(cp)); err != nil {
+			return err
+		}
+	}
+	if _, err := s.db.Exec(c.SQL); err != nil {
+		err = fmt.Errorf("apply %s:%d: %v", c.File, c.Pos, err)
+		if !s.store.Transactional() {
+			// 执行失败的DDL没有生效，恢复之前的检查点
+			if serr := s.store.Save(nil, s.pending(nil)); serr != nil {
+				err = fmt.Errorf("%v, restore checkpoint: %v", err, serr)
+			}
+		}
+		return err
+	}
+	if s.store.Transactional() {
+		if err := s.store.Save(nil, cp); err != nil {
+			return err
+		}
+	}
+	return s.commitCheckpoint(cp)
+}
+
+// pending 返回上次的检查点加上正在提交的 cp，用于不支持事务的检查点在提交前保存
+func (s *ApplySink) pending(cp *Checkpoint) *Checkpoint {
+	p := &Checkpoint{Flashback: s.flashback}
+	if s.last != nil {
+		*p = *s.last
+	}
+	p.Pending = cp
+	return p
+}
+
+// commitCheckpoint 记录已提交的检查点，不支持事务的检查点在此时保存
+func (s *ApplySink) commitCheckpoint(cp *Checkpoint) error {
+	s.last = cp
+	if s.store.Transactional() {
+		return nil
+	}
+	return s.store.Save(nil, cp)
+}
+
+func (s *ApplySink) Flush() error {
+	return nil
+}
+
+func (s *ApplySink) Close() error {
+	return s.db.Close()
+}
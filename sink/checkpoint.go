@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"binlog2sql_go/core"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultCheckpointFile 为未指定 -checkpoint-file/-checkpoint-table 时使用的检查点文件
+const defaultCheckpointFile = "binlog2sql_go.checkpoint"
+
+// Checkpoint 为 -apply-to 已执行到的binlog位置。正向执行时为最后一个已执行事务的结束位置，
+// 回滚时为最后一个已回滚事务的起始位置
+type Checkpoint struct {
+	File      string `json:"file"`
+	Pos       uint32 `json:"pos"`
+	Gtid      string `json:"gtid,omitempty"`
+	Flashback bool   `json:"flashback"`
+	// Pending 为文件检查点中正在提交的事务，提交并保存检查点后清除
+	Pending *Checkpoint `json:"pending,omitempty"`
+}
+
+type checkpointStore interface {
+	// Load 读取检查点，不存在时返回nil
+	Load() (*Checkpoint, error)
+	// Save 保存检查点，tx 不为nil时与SQL在同一个事务中保存
+	Save(tx *sql.Tx, cp *Checkpoint) error
+	// Transactional 检查点能否与SQL在同一个事务中保存，不能时在事务提交后保存
+	Transactional() bool
+}
+
+// fileCheckpoint 将检查点以JSON保存在本地文件中，写临时文件后重命名，保证文件内容完整。
+// 检查点不能与SQL在同一个事务中提交，提交前先记录正在提交的事务(Pending)，
+// 中断在提交和保存检查点之间时无法确定该事务是否已执行，需要人工确认后才能继续
+type fileCheckpoint struct {
+	name string
+}
+
+func (f *fileCheckpoint) Load() (*Checkpoint, error) {
+	data, err := os.ReadFile(f.name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("parse checkpoint file %s: %v", f.name, err)
+	}
+	if p := cp.Pending; p != nil {
+		return nil, fmt.Errorf("checkpoint file %s: the transaction at %s:%d was being committed when the last run stopped and may already be applied on the target, "+
+			"check the target, then replace the checkpoint with its \"pending\" entry if it was applied or remove the entry if not", f.name, p.File, p.Pos)
+	}
+	// 只有第一个事务提交前记录的 Pending 时还没有检查点
+	if cp.File == "" {
+		return nil, nil
+	}
+	return cp, nil
+}
+
+func (f *fileCheckpoint) Save(tx *sql.Tx, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := f.name + ".tmp"
+	if err = os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.name)
+}
+
+func (f *fileCheckpoint) Transactional() bool {
+	return false
+}
+
+// tableCheckpoint 将检查点保存在目标库的表中，与执行的SQL在同一个事务中提交，中断后不会重复或遗漏事务
+type tableCheckpoint struct {
+	db    *sql.DB
+	table string
+}
+
+func newTableCheckpoint(db *sql.DB, name string) (*tableCheckpoint, error) {
+	var quoted []string
+	for _, part := range strings.SplitN(name, ".", 2) {
+		quoted = append(quoted, core.QuoteIdent(part))
+	}
+	t := &tableCheckpoint{db: db, table: strings.Join(quoted, ".")}
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+		"`id` tinyint unsigned NOT NULL PRIMARY KEY,"+
+		"`binlog_file` varchar(255) NOT NULL,"+
+		"`binlog_pos` bigint unsigned NOT NULL,"+
+		"`gtid` varchar(255) NOT NULL DEFAULT '',"+
+		"`flashback` tinyint(1) NOT NULL DEFAULT 0,"+
+		"`updated_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP)", t.table))
+	if err != nil {
+		return nil, fmt.Errorf("create checkpoint table %s: %v", name, err)
+	}
+	return t, nil
+}
+
+func (t *tableCheckpoint) Load() (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	err := t.db.QueryRow(fmt.Sprintf("SELECT `binlog_file`,`binlog_pos`,`gtid`,`flashback` FROM %s WHERE `id`=1", t.table)).
+		Scan(&cp.File, &cp.Pos, &cp.Gtid, &cp.Flashback)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cp, err
+}
+
+func (t *tableCheckpoint) Save(tx *sql.Tx, cp *Checkpoint) error {
+	query := fmt.Sprintf("INSERT INTO %s (`id`,`binlog_file`,`binlog_pos`,`gtid`,`flashback`) VALUES (1,?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE `binlog_file`=VALUES(`binlog_file`),`binlog_pos`=VALUES(`binlog_pos`),`gtid`=VALUES(`gtid`),`flashback`=VALUES(`flashback`)", t.table)
+	var err error
+	if tx != nil {
+		_, err = tx.Exec(query, cp.File, cp.Pos, cp.Gtid, cp.Flashback)
+	} else {
+		_, err = t.db.Exec(query, cp.File, cp.Pos, cp.Gtid, cp.Flashback)
+	}
+	return err
+}
+
+func (t *tableCheckpoint) Transactional() bool {
+	return true
+}
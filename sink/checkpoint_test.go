@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileCheckpoint(t *testing.T) {
+	store := &fileCheckpoint{name: filepath.Join(t.TempDir(), "cp")}
+	cp, err := store.Load()
+	if err != nil || cp != nil {
+		t.Fatalf("expect no checkpoint, got %+v %v", cp, err)
+	}
+	want := &Checkpoint{File: "mysql-bin.000003", Pos: 1234, Gtid: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+	if err = store.Save(nil, want); err != nil {
+		t.Fatal(err)
+	}
+	if cp, err = store.Load(); err != nil || !reflect.DeepEqual(cp, want) {
+		t.Fatalf("got %+v %v, want %+v", cp, err, want)
+	}
+}
+
+func TestFileCheckpointPending(t *testing.T) {
+	store := &fileCheckpoint{name: filepath.Join(t.TempDir(), "cp")}
+	s := &ApplySink{store: store}
+	// 第一个事务提交前中断
+	if err := store.Save(nil, s.pending(&Checkpoint{File: "mysql-bin.000003", Pos: 1234})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(); err == nil || !strings.Contains(err.Error(), "mysql-bin.000003:1234") {
+		t.Fatalf("expect pending error, got %v", err)
+	}
+	// 执行失败的DDL恢复为没有检查点
+	if err := store.Save(nil, s.pending(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if cp, err := store.Load(); err != nil || cp != nil {
+		t.Fatalf("expect no checkpoint, got %+v %v", cp, err)
+	}
+	s.last = &Checkpoint{File: "mysql-bin.000003", Pos: 1234}
+	if err := store.Save(nil, s.pending(&Checkpoint{File: "mysql-bin.000003", Pos: 2000})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(); err == nil || !strings.Contains(err.Error(), "mysql-bin.000003:2000") {
+		t.Fatalf("expect pending error, got %v", err)
+	}
+	if err := store.Save(nil, s.last); err != nil {
+		t.Fatal(err)
+	}
+	if cp, err := store.Load(); err != nil || !reflect.DeepEqual(cp, s.last) {
+		t.Fatalf("got %+v %v, want %+v", cp, err, s.last)
+	}
+}
@@ -0,0 +1,253 @@
+package sink
+
+import (
+	"binlog2sql_go/core"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// csvNull 为CSV中NULL的表示方式，与 LOAD DATA INFILE 一致
+const csvNull = `\N`
+
+// maxOpenCsvFiles 为同时打开的文件数上限，超过时关闭最久未写入的文件，之后再写入时以追加方式重新打开
+const maxOpenCsvFiles = 64
+
+// csvSink 将行变更按表分别写入 <dir>/<schema>.<table>.csv（TSV为.tsv，gzip时追加.gz），
+// 表结构变化后列不同的变更写入新的文件 <schema>.<table>.<n>.csv
+type csvSink struct {
+	dir   string
+	comma rune
+	ext   string
+	gzip  bool
+	loc   *time.Location
+	files map[string]*csvFile
+	// maxOpen 为同时打开的文件数上限，opened 为当前打开的文件数，tick 用于记录文件最近一次写入的顺序
+	maxOpen int
+	opened  int
+	tick    uint64
+}
+
+type csvFile struct {
+	name    string
+	out     writer
+	w       *csv.Writer
+	columns []string
+	seq     int
+	used    uint64
+}
+
+// NewCsvSink 创建按表导出的CSV输出，tsv为true时使用制表符分隔，事件时间按 loc 输出
+func NewCsvSink(dir string, tsv, gzip bool, loc *time.Location) (Sink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &csvSink{dir: dir, comma: ',', ext: ".csv", gzip: gzip, loc: loc, files: make(map[string]*csvFile), maxOpen: maxOpenCsvFiles}
+	if tsv {
+		s.comma, s.ext = '\t', ".tsv"
+	}
+	if gzip {
+		s.ext += ".gz"
+	}
+	return s, nil
+}
+
+// WriteChange 写入一行变更，列为 op,binlog_file,pos,ts,gtid,before_<列>...,after_<列>...
+func (s *csvSink) WriteChange(change *Change) error {
+	c := change.Row
+	if c == nil {
+		return fmt.Errorf("csv output needs a row change")
+	}
+	key := c.Schema + "." + c.Table
+	cf := s.files[key]
+	if cf == nil || !equalStrings(cf.columns, c.Columns) {
+		seq := 0
+		if cf != nil {
+			if err := s.close(cf); err != nil {
+				return err
+			}
+			seq = cf.seq + 1
+		}
+		if err := s.evict(); err != nil {
+			return err
+		}
+		var err error
+		if cf, err = s.open(c, seq); err != nil {
+			return err
+		}
+		s.files[key] = cf
+	} else if cf.out == nil {
+		if err := s.evict(); err != nil {
+			return err
+		}
+		if err := s.reopen(cf); err != nil {
+			return err
+		}
+	}
+	s.tick++
+	cf.used = s.tick
+	record := []string{c.Type, c.File, fmt.Sprint(c.StartPos), time.Unix(int64(c.Timestamp), 0).In(s.loc).Format("2006-01-02 15:04:05"), c.Gtid}
+	for _, row := range []map[string]interface{}{c.Before, c.After} {
+		for _, col := range c.Columns {
+			if row == nil {
+				record = append(record, "")
+				continue
+			}
+			record = append(record, csvValue(row[col]))
+		}
+	}
+	return cf.w.Write(record)
+}
+
+// WriteDDL CSV只导出数据变更，忽略DDL
+func (s *csvSink) WriteDDL(c *Change) error {
+	return nil
+}
+
+func (s *csvSink) open(c *core.RowChange, seq int) (*csvFile, error) {
+	name := c.Schema + "." + c.Table
+	if seq > 0 {
+		name = fmt.Sprintf("%s.%d", name, seq)
+	}
+	// 库表名中的路径分隔符不能出现在文件名中
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	cf := &csvFile{name: filepath.Join(s.dir, name+s.ext), columns: c.Columns, seq: seq}
+	out, err := NewFile(cf.name, s.gzip)
+	if err != nil {
+		return nil, err
+	}
+	cf.setWriter(out, s.comma)
+	header := []string{"op", "binlog_file", "pos", "ts", "gtid"}
+	for _, prefix := range []string{"before_", "after_"} {
+		for _, col := range c.Columns {
+			header = append(header, prefix+col)
+		}
+	}
+	if err = cf.w.Write(header); err != nil {
+		out.Close()
+		return nil, err
+	}
+	s.opened++
+	return cf, nil
+}
+
+// reopen 以追加方式重新打开被关闭的文件，不再写入表头
+func (s *csvSink) reopen(cf *csvFile) error {
+	out, err := AppendFile(cf.name, s.gzip)
+	if err != nil {
+		return err
+	}
+	cf.setWriter(out, s.comma)
+	s.opened++
+	return nil
+}
+
+func (cf *csvFile) setWriter(out writer, comma rune) {
+	cf.out, cf.w = out, csv.NewWriter(out)
+	cf.w.Comma = comma
+}
+
+// evict 打开的文件数达到上限时关闭最久未写入的文件
+func (s *csvSink) evict() error {
+	if s.opened < s.maxOpen {
+		return nil
+	}
+	var oldest *csvFile
+	for _, cf := range s.files {
+		if cf.out != nil && (oldest == nil || cf.used < oldest.used) {
+			oldest = cf
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	return s.close(oldest)
+}
+
+// close 关闭文件，文件的列及序号仍保留，之后可以重新打开
+func (s *csvSink) close(cf *csvFile) error {
+	if cf.out == nil {
+		return nil
+	}
+	err := cf.close()
+	cf.out, cf.w = nil, nil
+	s.opened--
+	return err
+}
+
+func (cf *csvFile) flush() error {
+	cf.w.Flush()
+	if err := cf.w.Error(); err != nil {
+		return err
+	}
+	return cf.out.Flush()
+}
+
+func (cf *csvFile) close() error {
+	err := cf.flush()
+	if cerr := cf.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *csvSink) Flush() error {
+	for _, cf := range s.files {
+		if cf.out == nil {
+			continue
+		}
+		if err := cf.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 刷新并关闭所有文件
+func (s *csvSink) Close() error {
+	var err error
+	for key, cf := range s.files {
+		if cerr := s.close(cf); err == nil {
+			err = cerr
+		}
+		delete(s.files, key)
+	}
+	return err
+}
+
+// csvValue 将行变更中的值格式化为CSV字段，NULL为\N，二进制数据为0x开头的十六进制。
+// 以反斜杠或0x开头的字符串在前面加一个反斜杠，与NULL及二进制数据区分：读取时以反斜杠开头的字段除 \N 外去掉第一个反斜杠
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return csvNull
+	case []byte:
+		return "0x" + strings.ToUpper(hex.EncodeToString(val))
+	case json.RawMessage:
+		return string(val)
+	case string:
+		if strings.HasPrefix(val, `\`) || strings.HasPrefix(val, "0x") {
+			return `\` + val
+		}
+		return val
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
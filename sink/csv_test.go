@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"binlog2sql_go/core"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCsvSink(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewCsvSink(dir, false, false, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []string{"id", "note"}
+	changes := []*core.RowChange{
+		{Schema: "db", Table: "t", Type: "INSERT", Columns: columns, File: "mysql-bin.000001", StartPos: 100, Timestamp: 0,
+			After: map[string]interface{}{"id": int32(1), "note": "a,\"b\"\nc"}},
+		{Schema: "db", Table: "t", Type: "UPDATE", Columns: columns, File: "mysql-bin.000001", StartPos: 200, Timestamp: 0, Gtid: "g:1",
+			Before: map[string]interface{}{"id": int32(1), "note": nil}, After: map[string]interface{}{"id": int32(1), "note": []byte{0xff}}},
+		// 表结构变化后写入新的文件
+		{Schema: "db", Table: "t", Type: "DELETE", Columns: []string{"id"}, File: "mysql-bin.000001", StartPos: 300, Timestamp: 0,
+			Before: map[string]interface{}{"id": int32(1)}},
+	}
+	for _, c := range changes {
+		if err = w.WriteChange(&Change{Row: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(0, 0).UTC().Format("2006-01-02 15:04:05")
+	b, err := os.ReadFile(filepath.Join(dir, "db.t.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "op,binlog_file,pos,ts,gtid,before_id,before_note,after_id,after_note\n" +
+		"INSERT,mysql-bin.000001,100," + ts + ",,,,1,\"a,\"\"b\"\"\nc\"\n" +
+		"UPDATE,mysql-bin.000001,200," + ts + ",g:1,1,\\N,1,0xFF\n"
+	if string(b) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b, want)
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "db.t.1.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want = "op,binlog_file,pos,ts,gtid,before_id,after_id\nDELETE,mysql-bin.000001,300," + ts + ",,1,\n"; string(b) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b, want)
+	}
+}
+
+func Test_csvValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, `\N`},
+		{`\N`, `\\N`},
+		{`\x`, `\\x`},
+		{"0xFF", `\0xFF`},
+		{[]byte{0xff}, "0xFF"},
+		{"a\\N", "a\\N"},
+		{int64(-1), "-1"},
+	}
+	for _, tt := range tests {
+		if got := csvValue(tt.v); got != tt.want {
+			t.Errorf("csvValue(%#v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestCsvSinkMaxOpen(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewCsvSink(dir, false, true, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.(*csvSink).maxOpen = 2
+	// 三个表交替写入，超过上限时关闭最久未写入的文件，再次写入时追加
+	for i := 0; i < 6; i++ {
+		c := &core.RowChange{Schema: "db", Table: fmt.Sprintf("t%d", i%3), Type: "INSERT", Columns: []string{"id"},
+			After: map[string]interface{}{"id": i}}
+		if err = w.WriteChange(&Change{Row: c}); err != nil {
+			t.Fatal(err)
+		}
+		if opened := w.(*csvSink).opened; opened > 2 {
+			t.Fatalf("%d files opened", opened)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(0, 0).UTC().Format("2006-01-02 15:04:05")
+	for i := 0; i < 3; i++ {
+		f, err := os.Open(filepath.Join(dir, fmt.Sprintf("db.t%d.csv.gz", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("op,binlog_file,pos,ts,gtid,before_id,after_id\nINSERT,,0,%s,,,%d\nINSERT,,0,%s,,,%d\n", ts, i, ts, i+3)
+		if string(b) != want {
+			t.Errorf("got:\n%s\nwant:\n%s", b, want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func encodeSql(c *Change) ([]byte, error) {
+	return []byte(c.SQL + "\n"), nil
+}
+
+func encodeJson(c *Change) ([]byte, error) {
+	if c.Row == nil {
+		return nil, fmt.Errorf("json output needs a row change")
+	}
+	b, err := json.Marshal(c.Row)
+	return append(b, '\n'), err
+}
+
+func encodeDebezium(c *Change) ([]byte, error) {
+	if c.Row == nil {
+		return nil, fmt.Errorf("debezium output needs a row change")
+	}
+	b, err := json.Marshal(c.Row.Debezium(time.Now()))
+	return append(b, '\n'), err
+}
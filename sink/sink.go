@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"binlog2sql_go/conf"
+	"binlog2sql_go/core"
+	"fmt"
+	"io"
+)
+
+// Change 为一条输出的数据变更：SQL格式时为生成的SQL（可以是多行或整个事务），结构化格式时为行变更。
+// File、Pos、Gtid 为执行完该变更后的binlog位置，回滚时为被回滚的SQL的起始位置，用于 -apply-to 记录进度
+type Change struct {
+	SQL  string
+	Row  *core.RowChange
+	File string
+	Pos  uint32
+	Gtid string
+}
+
+// Sink 为输出目标，输出格式（SQL、JSON、CSV）与输出位置（标准输出、文件、滚动文件、gzip）相互独立
+type Sink interface {
+	// WriteChange 写入一条数据变更，同一次调用的内容不会被滚动拆分到两个文件中
+	WriteChange(c *Change) error
+	// WriteDDL 写入DDL，不支持DDL的格式忽略
+	WriteDDL(c *Change) error
+	// Flush 将缓冲的输出写入文件，在事务结束时调用
+	Flush() error
+	Close() error
+}
+
+// New 按 -apply-to、-output-format、-output-file、-rotate-size、-gzip 创建输出
+func New(cfg *conf.Config) (Sink, error) {
+	if cfg.ApplyTo != "" {
+		return NewApplySink(cfg.ApplyTo, cfg.Flashback, cfg.CheckpointFile, cfg.CheckpointTable)
+	}
+	if cfg.OutputFormat == "csv" || cfg.OutputFormat == "tsv" {
+		return NewCsvSink(cfg.OutputDir, cfg.OutputFormat == "tsv", cfg.Gzip, cfg.Location())
+	}
+	var w writer
+	var err error
+	switch {
+	case cfg.OutputFile == "":
+		if cfg.Gzip {
+			w = newGzipWriter(nopCloser{Stdout})
+		} else {
+			w = Stdout
+		}
+	case cfg.RotateSize != 0:
+		w, err = NewRotatingFile(cfg.OutputFile, uint64(cfg.RotateSize), cfg.Gzip)
+	default:
+		w, err = NewFile(cfg.OutputFile, cfg.Gzip)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamSink(cfg.OutputFormat, w)
+}
+
+// streamSink 将每条变更按格式编码后写入一个输出流
+type streamSink struct {
+	w      writer
+	encode func(c *Change) ([]byte, error)
+	ddl    bool
+}
+
+// NewStreamSink 创建写入单个输出流的Sink，format 为 sql、json 或 debezium
+func NewStreamSink(format string, w writer) (Sink, error) {
+	s := &streamSink{w: w}
+	switch format {
+	case "sql":
+		s.encode, s.ddl = encodeSql, true
+	case "json":
+		s.encode = encodeJson
+	case "debezium":
+		s.encode = encodeDebezium
+	default:
+		return nil, fmt.Errorf("unsupported output format %s", format)
+	}
+	return s, nil
+}
+
+func (s *streamSink) WriteChange(c *Change) error {
+	b, err := s.encode(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(b)
+	return err
+}
+
+func (s *streamSink) WriteDDL(c *Change) error {
+	if !s.ddl {
+		return nil
+	}
+	_, err := io.WriteString(s.w, c.SQL+"\n")
+	return err
+}
+
+func (s *streamSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *streamSink) Close() error {
+	return s.w.Close()
+}
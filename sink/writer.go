@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// writer 为输出位置，Flush 将缓冲的数据写入底层文件
+type writer interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+type stdoutWriter struct{}
+
+// Stdout 为标准输出，不缓冲，-stop-never 时可以实时看到输出
+var Stdout writer = stdoutWriter{}
+
+func (stdoutWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdoutWriter) Flush() error {
+	return nil
+}
+
+func (stdoutWriter) Close() error {
+	return nil
+}
+
+// nopCloser 使 Close 只刷新而不关闭底层输出，用于标准输出
+type nopCloser struct {
+	writer
+}
+
+func (w nopCloser) Close() error {
+	return w.Flush()
+}
+
+type fileWriter struct {
+	f   *os.File
+	buf *bufio.Writer
+}
+
+// NewFile 创建输出文件，gzip 为true时压缩写入，文件名不会自动添加 .gz 后缀
+func NewFile(name string, gzip bool) (writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return newFileWriter(f, gzip), nil
+}
+
+// AppendFile 以追加方式打开输出文件，gzip 时追加的内容为一个新的gzip成员，解压时与之前的内容连在一起
+func AppendFile(name string, gzip bool) (writer, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newFileWriter(f, gzip), nil
+}
+
+func newFileWriter(f *os.File, gzip bool) writer {
+	var w writer = &fileWriter{f: f, buf: bufio.NewWriter(f)}
+	if gzip {
+		w = newGzipWriter(w)
+	}
+	return w
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *fileWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+func (w *fileWriter) Close() error {
+	err := w.buf.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type gzipWriter struct {
+	gz *gzip.Writer
+	w  writer
+}
+
+func newGzipWriter(w writer) writer {
+	return &gzipWriter{gz: gzip.NewWriter(w), w: w}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+func (w *gzipWriter) Flush() error {
+	if err := w.gz.Flush(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *gzipWriter) Close() error {
+	err := w.gz.Close()
+	if cerr := w.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RotatingFile 为按大小滚动的输出文件，依次写入 name.000001、name.000002...，
+// gzip 时文件名追加 .gz。maxSize 按压缩前的大小计算，单次写入的内容不会被拆分
+type RotatingFile struct {
+	name    string
+	maxSize uint64
+	gzip    bool
+	seq     int
+	size    uint64
+	w       writer
+}
+
+func NewRotatingFile(name string, maxSize uint64, gzip bool) (*RotatingFile, error) {
+	r := &RotatingFile{name: name, maxSize: maxSize, gzip: gzip}
+	if err := r.rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Name 返回当前写入的文件名
+func (r *RotatingFile) Name() string {
+	name := fmt.Sprintf("%s.%06d", r.name, r.seq)
+	if r.gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+func (r *RotatingFile) rotate() error {
+	if r.w != nil {
+		if err := r.w.Close(); err != nil {
+			return err
+		}
+	}
+	r.seq++
+	r.size = 0
+	w, err := NewFile(r.Name(), r.gzip)
+	if err != nil {
+		return err
+	}
+	r.w = w
+	if r.seq > 1 {
+		fmt.Fprintf(os.Stderr, "#Rotate output to %s\n", r.Name())
+	}
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	if r.size != 0 && r.size+uint64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.w.Write(p)
+	r.size += uint64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Flush() error {
+	return r.w.Flush()
+}
+
+func (r *RotatingFile) Close() error {
+	return r.w.Close()
+}
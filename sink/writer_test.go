@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.sql")
+	r, err := NewRotatingFile(name, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := NewStreamSink("sql", r)
+	// 单次写入超过上限时不拆分
+	for _, sql := range []string{"BEGIN;\nSELECT 1;\nCOMMIT;", "a;", "bb;", "c;"} {
+		if err = s.WriteChange(&Change{SQL: sql}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{
+		name + ".000001": "BEGIN;\nSELECT 1;\nCOMMIT;\n",
+		name + ".000002": "a;\nbb;\nc;\n",
+	} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", file, b, want)
+		}
+	}
+}
+
+func TestGzipFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.sql.gz")
+	w, err := NewFile(name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := NewStreamSink("sql", w)
+	_ = s.WriteChange(&Change{SQL: "DELETE FROM t;"})
+	_ = s.WriteDDL(&Change{SQL: "DROP TABLE t;"})
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "DELETE FROM t;\nDROP TABLE t;\n" {
+		t.Fatalf("got %q", b)
+	}
+}
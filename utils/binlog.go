@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var binlogNameRegexp = regexp.MustCompile(`^(.+)\.(\d+)$`)
+
+// BinlogSeq 返回binlog文件名的前缀和序号，如 mysql-bin.000012 返回 mysql-bin 和 12
+func BinlogSeq(name string) (prefix string, seq int, ok bool) {
+	m := binlogNameRegexp.FindStringSubmatch(filepath.Base(name))
+	if m == nil {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return m[1], seq, true
+}
+
+// ResolveBinlogFiles 将 -local-file 的参数展开为按序号排序的binlog文件列表，
+// 参数可以是文件、通配符、目录(取其中所有binlog文件)或 mysql-bin.index 索引文件
+func ResolveBinlogFiles(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(name string) {
+		if abs, err := filepath.Abs(name); err == nil {
+			name = abs
+		}
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+	for _, arg := range args {
+		if arg = strings.TrimSpace(arg); arg == "" {
+			continue
+		}
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no binlog file matches %s", arg)
+			}
+			for _, m := range matches {
+				if _, _, ok := BinlogSeq(m); ok {
+					add(m)
+				}
+			}
+			continue
+		}
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case fi.IsDir():
+			entries, err := os.ReadDir(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if _, _, ok := BinlogSeq(entry.Name()); ok && !entry.IsDir() {
+					add(filepath.Join(arg, entry.Name()))
+				}
+			}
+		case strings.HasSuffix(arg, ".index"):
+			names, err := readBinlogIndex(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				add(name)
+			}
+		default:
+			add(arg)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		pi, si, _ := BinlogSeq(files[i])
+		pj, sj, _ := BinlogSeq(files[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return si < sj
+	})
+	return files, nil
+}
+
+// readBinlogIndex 读取binlog索引文件，索引中的相对路径以及已不存在的路径按索引文件所在目录查找
+func readBinlogIndex(index string) (names []string, err error) {
+	f, err := os.Open(index)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir := filepath.Dir(index)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" {
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		if _, err := os.Stat(name); err != nil {
+			name = filepath.Join(dir, filepath.Base(name))
+		}
+		names = append(names, name)
+	}
+	return names, scanner.Err()
+}
+
+// FilterBinlogFiles 只保留序号在 startFile 到 stopFile 之间的文件，为空时不限制
+func FilterBinlogFiles(files []string, startFile, stopFile string) (res []string) {
+	_, start, hasStart := BinlogSeq(startFile)
+	_, stop, hasStop := BinlogSeq(stopFile)
+	for _, f := range files {
+		_, seq, ok := BinlogSeq(f)
+		if ok && hasStart && seq < start {
+			continue
+		}
+		if ok && hasStop && seq > stop {
+			continue
+		}
+		res = append(res, f)
+	}
+	return
+}
+
+// SearchBinlogFile 二分查找 t 时刻所在的binlog文件，即第一个事件时间早于 t 的最后一个文件，
+// 第一个事件恰好在 t 时上一个文件末尾可能还有同一秒的事件，从上一个文件开始；t 不晚于所有文件时返回第一个文件。files 需按序号排列，firstEventTime 返回文件中第一个事件的时间
+func SearchBinlogFile(files []string, t time.Time, firstEventTime func(file string) (time.Time, error)) (string, error) {
+	if len(files) == 0 {
+		return "", fmt.Errorf("no binlog file to search")
+	}
+	var err error
+	i := sort.Search(len(files), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var ft time.Time
+		if ft, err = firstEventTime(files[i]); err != nil {
+			return true
+		}
+		return !ft.Before(t)
+	})
+	if err != nil {
+		return "", err
+	}
+	if i > 0 {
+		i--
+	}
+	return files[i], nil
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveBinlogFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mysql-bin.000010", "mysql-bin.000002", "mysql-bin.000009", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := filepath.Join(dir, "mysql-bin.index")
+	if err := os.WriteFile(index, []byte("/var/lib/mysql/mysql-bin.000009\n./mysql-bin.000010\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{filepath.Join(dir, "mysql-bin.000002"), filepath.Join(dir, "mysql-bin.000009"), filepath.Join(dir, "mysql-bin.000010")}
+	cases := [][]string{
+		{dir},
+		{filepath.Join(dir, "mysql-bin.*")},
+		{filepath.Join(dir, "mysql-bin.000010"), index, filepath.Join(dir, "mysql-bin.000002")},
+	}
+	for _, args := range cases {
+		files, err := ResolveBinlogFiles(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, all) {
+			t.Errorf("%v: got %v", args, files)
+		}
+	}
+	if files := FilterBinlogFiles(all, "mysql-bin.000003", "mysql-bin.000010"); !reflect.DeepEqual(files, all[1:]) {
+		t.Errorf("filter: got %v", files)
+	}
+	if _, err := ResolveBinlogFiles([]string{filepath.Join(dir, "missing.000001")}); err == nil {
+		t.Error("expect error for missing file")
+	}
+}
+
+func TestSearchBinlogFile(t *testing.T) {
+	files := []string{"mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003", "mysql-bin.000004"}
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	firstEventTime := func(file string) (time.Time, error) {
+		_, seq, _ := BinlogSeq(file)
+		return base.Add(time.Duration(seq) * time.Hour), nil
+	}
+	cases := []struct {
+		t    time.Time
+		want string
+	}{
+		{base, "mysql-bin.000001"},
+		{base.Add(time.Hour), "mysql-bin.000001"},
+		{base.Add(150 * time.Minute), "mysql-bin.000002"},
+		// 第一个事件恰好在 t 时，上一个文件末尾可能有同一秒的事件
+		{base.Add(3 * time.Hour), "mysql-bin.000002"},
+		{base.Add(3*time.Hour + time.Second), "mysql-bin.000003"},
+		{base.Add(24 * time.Hour), "mysql-bin.000004"},
+	}
+	for _, c := range cases {
+		if got, err := SearchBinlogFile(files, c.t, firstEventTime); err != nil || got != c.want {
+			t.Errorf("%v: got %s, %v, want %s", c.t, got, err, c.want)
+		}
+	}
+	if _, err := SearchBinlogFile(nil, base, firstEventTime); err == nil {
+		t.Error("expect error for no file")
+	}
+}